@@ -0,0 +1,143 @@
+package snappyframed
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+)
+
+// errInvalidFrame is returned by DecodeBlock when frame is not a single data
+// chunk.
+var errInvalidFrame = fmt.Errorf("invalid data frame")
+
+// EncodeBlock returns a single data chunk, including its header and checksum,
+// encoding src.  The chunk is compressed unless compression does not reduce
+// the size of src, exactly as by a Writer.  No stream identifier is written.
+// The returned frame is a subslice of dst if dst is large enough to hold it.
+// EncodeBlock returns an error wrapping ErrBlockTooLarge if src is longer than
+// MaxBlockSize.
+func EncodeBlock(dst, src []byte) ([]byte, error) {
+	if len(src) > maxBlockSize {
+		return nil, fmt.Errorf("%w: length %d > %d", ErrBlockTooLarge, len(src), maxBlockSize)
+	}
+	frame, _ := encodeFrame(dst, src, crc(src), nil)
+	return frame, nil
+}
+
+// EncodeBlocks returns a sequence of data chunks encoding src, split into
+// blocks of MaxBlockSize bytes as by a Writer which is not flushed.  Each
+// chunk is encoded as by EncodeBlock.  No stream identifier is written, and an
+// empty src results in no chunks.  The returned chunks are a subslice of dst
+// if dst is large enough to hold them.  EncodeBlocks returns an error if the
+// encoded length of src cannot be represented as an int.
+func EncodeBlocks(dst, src []byte) ([]byte, error) {
+	n := MaxEncodedLen(len(src))
+	if n < 0 {
+		return nil, fmt.Errorf("source too large %d", len(src))
+	}
+	n -= len(streamID)
+	if cap(dst) < n {
+		dst = make([]byte, 0, n)
+	}
+
+	dst = dst[:0]
+	for len(src) > 0 {
+		size := len(src)
+		if size > maxBlockSize {
+			size = maxBlockSize
+		}
+		// the frame is encoded in place when dst has room.
+		frame, _ := encodeFrame(dst[len(dst):], src[:size], crc(src[:size]), nil)
+		dst = append(dst, frame...)
+		src = src[size:]
+	}
+	return dst, nil
+}
+
+// encodeFrame encodes src as a data chunk with the given checksum, reusing dst
+// if it is large enough.  Whether the chunk is compressed is decided by
+// opts.compress, which allows a nil opts.  encodeFrame returns the chunk and
+// whether it is compressed.  The length of src must not exceed maxBlockSize.
+func encodeFrame(dst, src []byte, checksum uint32, opts *WriterOptions) ([]byte, bool) {
+	n := blockHeaderSize + snappy.MaxEncodedLen(len(src))
+	if cap(dst) < n {
+		dst = make([]byte, n)
+	}
+	dst = dst[:cap(dst)]
+
+	compressed := true
+	block := snappy.Encode(dst[blockHeaderSize:], src)
+	if !opts.compress(len(block), len(src)) {
+		compressed = false
+		block = dst[blockHeaderSize : blockHeaderSize+copy(dst[blockHeaderSize:], src)]
+	}
+	if compressed {
+		writeHeader(dst[:blockHeaderSize], blockCompressed, block, checksum)
+	} else {
+		writeHeader(dst[:blockHeaderSize], blockUncompressed, block, checksum)
+	}
+	return dst[:blockHeaderSize+len(block)], compressed
+}
+
+// DecodeBlock returns the data decoded from frame, a single data chunk such as
+// one returned by EncodeBlock.  The returned data is a subslice of dst if dst
+// is large enough to hold it.  DecodeBlock returns an error if frame is not a
+// compressed or uncompressed data chunk, and a *ChecksumError if its checksum
+// does not match the decoded data.
+func DecodeBlock(dst, frame []byte) ([]byte, error) {
+	if len(frame) < blockHeaderSize {
+		return nil, errInvalidFrame
+	}
+	btype := frame[0]
+	if btype != blockCompressed && btype != blockUncompressed {
+		return nil, errInvalidFrame
+	}
+	if int(decodeLength(frame[1:4])) != len(frame)-4 {
+		return nil, errInvalidFrame
+	}
+	if len(frame)-4 < minDataBlockSize {
+		return nil, ErrEmptyBlock
+	}
+	checksum := uint32(frame[4]) | uint32(frame[5])<<8 | uint32(frame[6])<<16 | uint32(frame[7])<<24
+	block := frame[blockHeaderSize:]
+
+	declen := len(block)
+	if btype == blockCompressed {
+		var err error
+		declen, err = snappy.DecodedLen(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if declen > maxBlockSize {
+		return nil, fmt.Errorf("%w: decoded length %d > %d", ErrBlockTooLarge, declen, maxBlockSize)
+	}
+
+	var dec []byte
+	if btype == blockCompressed {
+		var err error
+		dec, err = snappy.Decode(dst[:cap(dst)], block)
+		if err != nil {
+			return nil, err
+		}
+		if len(dec) != declen {
+			return nil, ErrDecodedLength
+		}
+	} else {
+		if cap(dst) < declen {
+			dst = make([]byte, declen)
+		}
+		dec = dst[:copy(dst[:cap(dst)], block)]
+	}
+	actualChecksum := crc(dec)
+	if checksum != actualChecksum {
+		return nil, &ChecksumError{
+			Length:     int64(len(frame)),
+			Compressed: btype == blockCompressed,
+			DecodedLen: len(dec),
+			Checksum:   checksum,
+			Actual:     actualChecksum,
+		}
+	}
+	return dec, nil
+}
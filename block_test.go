@@ -0,0 +1,123 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncodeBlock(t *testing.T) {
+	for _, src := range [][]byte{
+		{},
+		[]byte("short"),
+		bytes.Repeat([]byte("compressible "), 1000),
+		randBytes(t, 1000),
+		randBytes(t, MaxBlockSize),
+	} {
+		frame, err := EncodeBlock(nil, src)
+		if err != nil {
+			t.Fatalf("encode %d bytes: %v", len(src), err)
+		}
+		// an empty block is compressed to a single byte.
+		if len(frame) > blockHeaderSize+len(src)+1 {
+			t.Fatalf("encode %d bytes: frame too large %d", len(src), len(frame))
+		}
+
+		// a stream identifier followed by the frame is a valid stream
+		r := NewReader(bytes.NewReader(append(append([]byte{}, streamID...), frame...)))
+		var buf bytes.Buffer
+		_, err = r.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("read %d bytes: %v", len(src), err)
+		}
+		if !bytes.Equal(buf.Bytes(), src) {
+			t.Fatalf("read %d bytes: decoded data differs", len(src))
+		}
+
+		dec, err := DecodeBlock(make([]byte, 0, MaxBlockSize), frame)
+		if err != nil {
+			t.Fatalf("decode %d bytes: %v", len(src), err)
+		}
+		if !bytes.Equal(dec, src) {
+			t.Fatalf("decode %d bytes: decoded data differs", len(src))
+		}
+	}
+}
+
+func TestEncodeBlock_tooLarge(t *testing.T) {
+	_, err := EncodeBlock(nil, make([]byte, MaxBlockSize+1))
+	if !errors.Is(err, ErrBlockTooLarge) {
+		t.Fatalf("encode oversize block: %v", err)
+	}
+}
+
+// This test checks that EncodeBlocks splits its input into blocks of
+// MaxBlockSize bytes.
+func TestEncodeBlocks(t *testing.T) {
+	data := append(bytes.Repeat(testDataJSON, 10*MaxBlockSize/len(testDataJSON)), randBytes(t, MaxBlockSize)...)
+	for _, test := range []struct {
+		size   int
+		blocks int
+	}{
+		{0, 0},
+		{1, 1},
+		{MaxBlockSize, 1},
+		{MaxBlockSize + 1, 2},
+		{2 * MaxBlockSize, 2},
+		{10*MaxBlockSize + 17, 11},
+	} {
+		src := data[len(data)-test.size:]
+		dst := make([]byte, 0, MaxEncodedLen(len(src)))
+		frames, err := EncodeBlocks(dst, src)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", test.size, err)
+		}
+		if len(frames) > 0 && &frames[0] != &dst[:1][0] {
+			t.Errorf("%d bytes: frames not encoded in dst", test.size)
+		}
+
+		var blocks int
+		for p := frames; len(p) > 0; blocks++ {
+			typ, length := DecodeHeader(p)
+			if typ != ChunkCompressed && typ != ChunkUncompressed {
+				t.Fatalf("%d bytes: chunk type %#x", test.size, typ)
+			}
+			p = p[ChunkHeaderSize+length:]
+		}
+		if blocks != test.blocks {
+			t.Errorf("%d bytes: %d blocks (!= %d)", test.size, blocks, test.blocks)
+		}
+
+		r := NewReader(bytes.NewReader(append(append([]byte{}, streamID...), frames...)))
+		var buf bytes.Buffer
+		_, err = r.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("%d bytes: read: %v", test.size, err)
+		}
+		if !bytes.Equal(buf.Bytes(), src) {
+			t.Fatalf("%d bytes: decoded data differs", test.size)
+		}
+	}
+}
+
+func TestDecodeBlock_invalid(t *testing.T) {
+	frame, err := EncodeBlock(nil, bytes.Repeat([]byte("abc"), 100))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	corrupt := append([]byte{}, frame...)
+	corrupt[4] ^= 0xff
+	for _, test := range [][]byte{
+		frame[:blockHeaderSize-1],
+		frame[:len(frame)-1],
+		append(frame[:len(frame):len(frame)], 0),
+		append([]byte{blockPadding}, frame[1:]...),
+		corrupt,
+	} {
+		_, err := DecodeBlock(nil, test)
+		if err == nil {
+			t.Errorf("expected error decoding %x", test)
+		}
+	}
+}
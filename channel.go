@@ -0,0 +1,51 @@
+package snappyframed
+
+import "fmt"
+
+// NewChannelWriter returns a Writer which sends the encoded stream to ch as a
+// sequence of messages, each containing exactly one chunk.  Every message is
+// a new slice which the receiver may retain.  Sending blocks until ch can
+// accept the message, so a slow receiver of an unbuffered channel delays
+// Write, Flush, and Close.  Messages received in order form a valid stream.
+//
+// Close closes ch after the stream is complete, or when the stream cannot be
+// completed because of an error.  Close closes ch only once.  Data written to
+// the Writer is buffered as it is by a Writer returned by NewWriter.
+func NewChannelWriter(ch chan<- []byte) *Writer {
+	cw := &chanWriter{ch: ch}
+	sz := NewWriter(cw)
+	sz.closer = cw
+	return sz
+}
+
+// chanWriter splits the stream written to it into chunks which it sends to a
+// channel.
+type chanWriter struct {
+	ch  chan<- []byte
+	buf []byte // data of an incomplete chunk
+}
+
+func (w *chanWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for len(w.buf) >= 4 {
+		n := 4 + int(decodeLength(w.buf[1:]))
+		if len(w.buf) < n {
+			break
+		}
+		chunk := make([]byte, n)
+		copy(chunk, w.buf)
+		w.ch <- chunk
+		w.buf = w.buf[:copy(w.buf, w.buf[n:])]
+	}
+	return len(p), nil
+}
+
+// Close closes the channel.  An incomplete chunk is discarded and causes an
+// error.
+func (w *chanWriter) Close() error {
+	close(w.ch)
+	if len(w.buf) > 0 {
+		return fmt.Errorf("incomplete chunk of %d bytes", len(w.buf))
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+)
+
+// This test checks that a Writer returned by NewChannelWriter sends one chunk
+// per message and closes the channel when it is closed.
+func TestChannelWriter(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	ch := make(chan []byte)
+	done := make(chan [][]byte)
+	go func() {
+		var msgs [][]byte
+		for msg := range ch {
+			msgs = append(msgs, msg)
+		}
+		done <- msgs
+	}()
+
+	w := NewChannelWriter(ch)
+	w.Write(data[:10])
+	err := w.Flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	w.Write(data[10:])
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	msgs := <-done
+
+	if len(msgs) < 3 || !bytes.Equal(msgs[0], streamID) {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	for i, msg := range msgs {
+		if len(msg) < 4 || int(decodeLength(msg[1:])) != len(msg)-4 {
+			t.Fatalf("message %d is not a single chunk", i)
+		}
+	}
+	if len(msgs[1]) != 4+4+10 || msgs[1][0] != blockUncompressed {
+		t.Fatalf("flushed chunk: %x", msgs[1])
+	}
+
+	p, err := ioutil.ReadAll(NewReader(bytes.NewReader(bytes.Join(msgs, nil))))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+// This test checks that Close closes the channel of a Writer returned by
+// NewChannelWriter even if the stream cannot be completed, and only once.
+func TestChannelWriter_closeError(t *testing.T) {
+	errFail := fmt.Errorf("injected failure")
+	ch := make(chan []byte, 16)
+	w := NewChannelWriter(ch)
+	w.Write([]byte("data"))
+	w.err = errFail
+
+	err := w.Close()
+	if !errors.Is(err, errFail) {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case msg, ok := <-ch:
+		if ok {
+			t.Fatalf("unexpected message %x", msg)
+		}
+	default:
+		t.Fatalf("channel not closed")
+	}
+	err = w.Close()
+	if err == nil {
+		t.Fatalf("second close: expected error")
+	}
+}
@@ -0,0 +1,106 @@
+package snappyframed
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"math/bits"
+)
+
+// ChecksumKind selects the algorithm which computes the checksum stored in
+// each data chunk (see WriterOptions and ReaderOptions).  Checksums of every
+// kind are masked as CRC-32C checksums are.  Streams written with a kind other
+// than ChecksumCRC32C are not conformant and must be read by a Reader
+// configured with the same kind.  The stream checksum written with the
+// StreamChecksum option is always CRC-32C.
+type ChecksumKind int
+
+// Checksum kinds.
+const (
+	ChecksumCRC32C   ChecksumKind = iota // CRC-32C (Castagnoli), the default
+	ChecksumXXHash64                     // low 32 bits of xxHash64 with seed 0
+	ChecksumAdler32                      // Adler-32
+)
+
+// checksum returns the function computing masked checksums of kind k, or nil
+// for ChecksumCRC32C.  checksum panics if k is not a known kind.
+func (k ChecksumKind) checksum() func([]byte) uint32 {
+	switch k {
+	case ChecksumCRC32C:
+		return nil
+	case ChecksumXXHash64:
+		return func(p []byte) uint32 { return maskChecksum(uint32(xxhash64(p))) }
+	case ChecksumAdler32:
+		return func(p []byte) uint32 { return maskChecksum(adler32.Checksum(p)) }
+	}
+	panic(fmt.Sprintf("unknown checksum kind %d", k))
+}
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 returns the xxHash64 digest of p computed with a seed of 0.
+func xxhash64(p []byte) uint64 {
+	n := len(p)
+	var h uint64
+	if n >= 32 {
+		// the initial accumulators wrap, which constant expressions cannot.
+		prime1 := xxhPrime1
+		v1 := prime1 + xxhPrime2
+		v2 := xxhPrime2
+		v3 := uint64(0)
+		v4 := -prime1
+		for ; len(p) >= 32; p = p[32:] {
+			v1 = xxhRound(v1, binary.LittleEndian.Uint64(p))
+			v2 = xxhRound(v2, binary.LittleEndian.Uint64(p[8:]))
+			v3 = xxhRound(v3, binary.LittleEndian.Uint64(p[16:]))
+			v4 = xxhRound(v4, binary.LittleEndian.Uint64(p[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxhMergeRound(h, v1)
+		h = xxhMergeRound(h, v2)
+		h = xxhMergeRound(h, v3)
+		h = xxhMergeRound(h, v4)
+	} else {
+		h = xxhPrime5
+	}
+	h += uint64(n)
+
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		h ^= uint64(b) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxhPrime1
+}
+
+func xxhMergeRound(acc, v uint64) uint64 {
+	acc ^= xxhRound(0, v)
+	return acc*xxhPrime1 + xxhPrime4
+}
@@ -0,0 +1,54 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestXXHash64(t *testing.T) {
+	for _, test := range []struct {
+		data string
+		hash uint64
+	}{
+		{"", 0xef46db3751d8e999},
+		{"hello, world", 0xb33a384e6d1b1242},
+		{"abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$", 0x1032d841e824f998},
+	} {
+		if h := xxhash64([]byte(test.data)); h != test.hash {
+			t.Errorf("%q: %#x (!= %#x)", test.data, h, test.hash)
+		}
+	}
+}
+
+// This test checks that streams written with each ChecksumKind are read by a
+// Reader configured with the same kind, and rejected by Readers configured
+// with other kinds.
+func TestChecksumKind(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	kinds := []ChecksumKind{ChecksumCRC32C, ChecksumXXHash64, ChecksumAdler32}
+	for _, wkind := range kinds {
+		var buf bytes.Buffer
+		w := NewWriterOptions(&buf, &WriterOptions{ChecksumKind: wkind})
+		w.Write(data)
+		err := w.Close()
+		if err != nil {
+			t.Fatalf("kind %d: close: %v", wkind, err)
+		}
+
+		for _, rkind := range kinds {
+			r := NewReaderOptions(bytes.NewReader(buf.Bytes()), &ReaderOptions{ChecksumKind: rkind})
+			p, err := ioutil.ReadAll(r)
+			if rkind == wkind {
+				if err != nil {
+					t.Errorf("kind %d: read: %v", wkind, err)
+				} else if !bytes.Equal(p, data) {
+					t.Errorf("kind %d: decoded data differs", wkind)
+				}
+			} else if !errors.Is(err, ErrChecksumMismatch) {
+				t.Errorf("kind %d read as %d: %v", wkind, rkind, err)
+			}
+		}
+	}
+}
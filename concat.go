@@ -0,0 +1,45 @@
+package snappyframed
+
+import (
+	"fmt"
+	"io"
+)
+
+// Concat writes to dst a single stream whose decoded data is the decoded data
+// of each stream in streams, in order, without decoding any of them.  The
+// first stream is copied verbatim and the leading stream identifier of each
+// later stream is removed.  Empty streams contribute nothing.  Concat returns
+// an error if a non-empty stream does not begin with a stream identifier, in
+// which case dst may contain a partial result.
+//
+// Streams written with the StreamChecksum or Header options cannot be
+// concatenated this way, because their checksums and headers apply to a
+// stream beginning at a stream identifier.
+func Concat(dst io.Writer, streams ...io.Reader) error {
+	var wroteID bool
+	id := make([]byte, len(streamID))
+	for i, r := range streams {
+		_, err := io.ReadFull(r, id)
+		if err == io.EOF {
+			continue
+		}
+		if err == io.ErrUnexpectedEOF || err == nil && string(id) != string(streamID) {
+			err = errMissingStreamID
+		}
+		if err != nil {
+			return fmt.Errorf("stream %d: %w", i, err)
+		}
+		if !wroteID {
+			_, err = dst.Write(id)
+			if err != nil {
+				return err
+			}
+			wroteID = true
+		}
+		_, err = io.Copy(dst, r)
+		if err != nil {
+			return fmt.Errorf("stream %d: %w", i, err)
+		}
+	}
+	return nil
+}
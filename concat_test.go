@@ -0,0 +1,52 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestConcat(t *testing.T) {
+	parts := [][]byte{
+		[]byte("first part, "),
+		bytes.Repeat(testDataJSON, 2),
+		nil,
+		[]byte("last part"),
+	}
+	var streams []io.Reader
+	for _, p := range parts {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.Write(p)
+		err := w.Close()
+		if err != nil {
+			t.Fatalf("encode: %v", err)
+		}
+		streams = append(streams, &buf)
+	}
+
+	var buf bytes.Buffer
+	err := Concat(&buf, streams...)
+	if err != nil {
+		t.Fatalf("concat: %v", err)
+	}
+	if bytes.Count(buf.Bytes(), streamID) != 1 {
+		t.Fatalf("result does not contain exactly one stream identifier")
+	}
+	r := NewReader(&buf)
+	r.Multistream(false)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, bytes.Join(parts, nil)) {
+		t.Fatalf("decoded data differs")
+	}
+
+	err = Concat(ioutil.Discard, bytes.NewReader(streamID), bytes.NewReader(compressedChunk(t, []byte("x"))))
+	if !errors.Is(err, errMissingStreamID) {
+		t.Fatalf("missing identifier: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package snappyframed
+
+import (
+	"io"
+	"net"
+)
+
+// NewConn returns a net.Conn which encodes data written to it as a snappy
+// framed stream sent over c, and decodes data read from it from a snappy
+// framed stream received over c.  The peer must also encode its data, for
+// example by using NewConn itself.
+//
+// Each Write is encoded and flushed to c before it returns, so the peer may
+// decode the data immediately.  Read blocks until a complete data block has
+// been received from c.  Deadlines and addresses are those of c.  Because
+// Reader and Writer errors are persistent, the returned net.Conn cannot be
+// used after any error, including a timeout.
+//
+// Read and Write may be called concurrently with each other, but concurrent
+// calls to Write (or to Read) are not safe.  Close closes c.
+func NewConn(c net.Conn) net.Conn {
+	return &conn{
+		Conn: c,
+		r:    NewReader(c),
+		w:    NewWriter(c),
+	}
+}
+
+type conn struct {
+	net.Conn
+	r *Reader
+	w *Writer
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *conn) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	if err != nil {
+		return 0, err
+	}
+	err = c.w.Flush()
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Close closes the Writer, so that further calls to Write fail, and the
+// underlying connection.  Close returns the first error encountered.
+func (c *conn) Close() error {
+	err := c.w.Close()
+	cerr := c.Conn.Close()
+	if err == nil || err == errClosed {
+		err = cerr
+	}
+	return err
+}
+
+// NewReadWriter returns an io.ReadWriteCloser which decodes the snappy framed
+// stream read from r and encodes data written to it as a snappy framed stream
+// written to w.  Written data is buffered as it is by a Writer.  Close flushes
+// and closes the encoding Writer and releases the decoding Reader.  Close
+// does not close r or w.
+func NewReadWriter(r io.Reader, w io.Writer) io.ReadWriteCloser {
+	return &readWriter{
+		r: NewReader(r),
+		w: NewWriter(w),
+	}
+}
+
+type readWriter struct {
+	r *Reader
+	w *Writer
+}
+
+func (rw *readWriter) Read(p []byte) (int, error) {
+	return rw.r.Read(p)
+}
+
+func (rw *readWriter) Write(p []byte) (int, error) {
+	return rw.w.Write(p)
+}
+
+func (rw *readWriter) Close() error {
+	err := rw.w.Close()
+	rerr := rw.r.Close()
+	if err != nil {
+		return err
+	}
+	return rerr
+}
@@ -0,0 +1,113 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+// This test checks that messages sent in both directions over connections
+// returned by NewConn are received intact.
+func TestConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, server := NewConn(c1), NewConn(c2)
+	defer client.Close()
+
+	msgs := [][]byte{
+		[]byte("hello"),
+		bytes.Repeat([]byte("a large message "), MaxBlockSize/8),
+		[]byte("goodbye"),
+	}
+
+	// the server echoes each message in upper case.
+	done := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		for _, msg := range msgs {
+			p := make([]byte, len(msg))
+			_, err := io.ReadFull(server, p)
+			if err != nil {
+				done <- err
+				return
+			}
+			_, err = server.Write(bytes.ToUpper(p))
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	for _, msg := range msgs {
+		_, err := client.Write(msg)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		p := make([]byte, len(msg))
+		_, err = io.ReadFull(client, p)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(p, bytes.ToUpper(msg)) {
+			t.Fatalf("response differs: %.20q", p)
+		}
+	}
+	err := <-done
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+
+	// the server closed its connection at the end of a stream.
+	_, err = client.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Fatalf("read after close: %v", err)
+	}
+}
+
+// This test checks that a ReadWriter decodes data read from one buffer and
+// encodes data written to another.
+func TestReadWriter(t *testing.T) {
+	var in, out bytes.Buffer
+	w := NewWriter(&in)
+	w.Write([]byte("inbound data"))
+	w.Close()
+
+	rw := NewReadWriter(&in, &out)
+	p, err := ioutil.ReadAll(rw)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "inbound data" {
+		t.Fatalf("read: %q", p)
+	}
+	_, err = rw.Write([]byte("outbound data"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("write was not buffered")
+	}
+	err = rw.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	_, err = rw.Read(make([]byte, 1))
+	if err != ErrReaderClosed {
+		t.Fatalf("read after close: %v", err)
+	}
+	_, err = rw.Write([]byte("more"))
+	if err == nil {
+		t.Fatalf("write after close")
+	}
+
+	p, err = ioutil.ReadAll(NewReader(&out))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if string(p) != "outbound data" {
+		t.Fatalf("decode: %q", p)
+	}
+}
@@ -5,21 +5,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"sync"
 
 	snappyframed "."
 )
 
-// readerPool and writerPool are the simplest pool implementations for decoding
-// and encoding snappyframed streams.  A real application may wish to wrap
-// pools in a utility package that handles calling Get and Put on the pool
-// transparently.
-var readerPool = sync.Pool{New: func() interface{} { return snappyframed.NewReader(nil) }}
-var writerPool = sync.Pool{New: func() interface{} { return snappyframed.NewWriter(nil) }}
+// readerPool and writerPool hold the Readers and Writers used for decoding and
+// encoding snappyframed streams.  Get resets a pooled value for its new stream
+// and Put releases the stream, so the values may be reused safely.
+var readerPool = snappyframed.NewReaderPool()
+var writerPool = snappyframed.NewWriterPool()
 
 // APIRequest is sent to the API in a JSON POST request.
 type APIRequest struct {
@@ -38,14 +35,11 @@ func szAPIHandler(resp http.ResponseWriter, r *http.Request) {
 	body := r.Body // it seems important not to reassign r.Body..
 	defer body.Close()
 	if r.Header.Get("Content-Type") == snappyframed.MediaType {
-		// get a reader from the pool, replacing it when the handler has
-		// completed.  then set the underlying reader r.Body and reset to nil
-		// when the handler has completed.
-		sz := readerPool.Get().(*snappyframed.Reader)
+		// get a reader of r.Body from the pool, replacing it when the
+		// handler has completed.
+		sz := readerPool.Get(body)
 		defer readerPool.Put(sz)
-		sz.Reset(body)
-		defer sz.Reset(nil)
-		body = ioutil.NopCloser(sz)
+		body = sz
 	}
 
 	// decode the an APIRequest from the request entity.
@@ -60,11 +54,9 @@ func szAPIHandler(resp http.ResponseWriter, r *http.Request) {
 	// here is important and tricky.  A real application would probably want to
 	// implement an http.ResponseWriter capable of doing HTTP content
 	// negotiation and performing Reset automatically on creation and on Close.
-	resp.Header().Set("Content-Type", snappyframed.MediaType)
-	w := writerPool.Get().(*snappyframed.Writer)
+	snappyframed.SetFramedContentType(resp.Header())
+	w := writerPool.Get(resp)
 	defer writerPool.Put(w)
-	w.Reset(resp)
-	defer w.Reset(nil)
 	defer w.Close()
 
 	json.NewEncoder(w).Encode(APIResponse{
@@ -86,11 +78,9 @@ func Example_pool() {
 	// may benefit from not using defer statements.
 	req := APIRequest{"pooling example"}
 	var reqbuf bytes.Buffer
-	enc := writerPool.Get().(*snappyframed.Writer)
-	enc.Reset(&reqbuf)
+	enc := writerPool.Get(&reqbuf)
 	json.NewEncoder(enc).Encode(req)
 	enc.Close()
-	enc.Reset(nil)
 	writerPool.Put(enc)
 
 	resp, err := http.Post(server.URL, snappyframed.MediaType, &reqbuf)
@@ -102,11 +92,9 @@ func Example_pool() {
 	// the response in this case and just write it to stdout.
 	defer resp.Body.Close()
 	if resp.Header.Get("Content-Type") == snappyframed.MediaType {
-		sz := readerPool.Get().(*snappyframed.Reader)
+		sz := readerPool.Get(resp.Body)
 		defer readerPool.Put(sz)
-		sz.Reset(resp.Body)
-		defer sz.Reset(nil)
-		resp.Body = ioutil.NopCloser(sz)
+		resp.Body = sz
 	}
 	_, err = io.Copy(os.Stdout, resp.Body)
 	if err != nil {
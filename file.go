@@ -0,0 +1,81 @@
+package snappyframed
+
+import (
+	"io"
+	"os"
+)
+
+// FileOptions configures EncodeFileOptions and DecodeFileOptions.  The zero
+// value is the configuration used by EncodeFile and DecodeFile.
+type FileOptions struct {
+	// Sync causes the destination file to be synced to stable storage (see
+	// os.File.Sync) before it is closed.
+	Sync bool
+}
+
+// EncodeFile encodes the contents of the file src as a snappy framed stream
+// written to the file dst, which is created or truncated.  An empty src is
+// encoded as a stream containing only a stream identifier.  If an error is
+// encountered dst may contain a partial stream.
+func EncodeFile(dst, src string) error {
+	return EncodeFileOptions(dst, src, nil)
+}
+
+// EncodeFileOptions is like EncodeFile but configured with opts.  If opts is
+// nil EncodeFileOptions is equivalent to EncodeFile.
+func EncodeFileOptions(dst, src string, opts *FileOptions) error {
+	return copyFile(dst, src, opts, func(w io.Writer, r io.Reader) error {
+		sz := NewWriter(w)
+		_, err := io.Copy(sz, r)
+		if err != nil {
+			return err
+		}
+		err = sz.FlushFrame()
+		if err != nil {
+			return err
+		}
+		return sz.Close()
+	})
+}
+
+// DecodeFile decodes the snappy framed stream in the file src and writes the
+// decoded data to the file dst, which is created or truncated.  If an error
+// is encountered dst may contain partially decoded data.
+func DecodeFile(dst, src string) error {
+	return DecodeFileOptions(dst, src, nil)
+}
+
+// DecodeFileOptions is like DecodeFile but configured with opts.  If opts is
+// nil DecodeFileOptions is equivalent to DecodeFile.
+func DecodeFileOptions(dst, src string, opts *FileOptions) error {
+	return copyFile(dst, src, opts, func(w io.Writer, r io.Reader) error {
+		_, err := io.Copy(w, NewReader(r))
+		return err
+	})
+}
+
+// copyFile opens src, creates dst, and calls fn to transfer data between them.
+// dst is synced if opts requires it and closed.  The first error encountered
+// is returned.
+func copyFile(dst, src string, opts *FileOptions, fn func(w io.Writer, r io.Reader) error) error {
+	fsrc, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer fsrc.Close()
+
+	fdst, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	err = fn(fdst, fsrc)
+	if err == nil && opts != nil && opts.Sync {
+		err = fdst.Sync()
+	}
+	cerr := fdst.Close()
+	if err != nil {
+		return err
+	}
+	return cerr
+}
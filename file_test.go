@@ -0,0 +1,66 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// This test checks that files encoded with EncodeFile are restored by
+// DecodeFile, including empty files.
+func TestEncodeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snappyframed-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, data := range [][]byte{
+		nil,
+		[]byte("a small file"),
+		bytes.Repeat(testDataJSON, 10),
+	} {
+		orig := filepath.Join(dir, "orig")
+		enc := filepath.Join(dir, "orig"+Ext)
+		dec := filepath.Join(dir, "dec")
+		err := ioutil.WriteFile(orig, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = EncodeFile(enc, orig)
+		if err != nil {
+			t.Fatalf("%d: encode: %v", i, err)
+		}
+		p, err := ioutil.ReadFile(enc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(p, streamID) {
+			t.Fatalf("%d: encoded file lacks a stream identifier", i)
+		}
+
+		err = DecodeFileOptions(dec, enc, &FileOptions{Sync: true})
+		if err != nil {
+			t.Fatalf("%d: decode: %v", i, err)
+		}
+		p, err = ioutil.ReadFile(dec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("%d: decoded file differs", i)
+		}
+	}
+
+	err = DecodeFile(filepath.Join(dir, "dec"), filepath.Join(dir, "missing"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("decode missing file: %v", err)
+	}
+	err = DecodeFile(filepath.Join(dir, "dec"), filepath.Join(dir, "orig"))
+	if err == nil {
+		t.Fatalf("decoded a file which is not snappy framed")
+	}
+}
@@ -0,0 +1,91 @@
+//go:build go1.18
+
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzReader checks that a Reader does not panic on arbitrary input and that
+// its buffers never exceed the size of the largest valid block.
+func FuzzReader(f *testing.F) {
+	for _, data := range [][]byte{testDataMan, testDataJSON} {
+		enc, err := encodeStreamBytes(data, false)
+		if err != nil {
+			f.Fatalf("encode: %v", err)
+		}
+		f.Add(enc)
+		f.Add(enc[:len(enc)/2])
+		corrupt := append([]byte(nil), enc...)
+		corrupt[len(corrupt)/2] ^= 0xff
+		f.Add(corrupt)
+	}
+	f.Add([]byte{})
+	f.Add(streamID)
+	f.Add(append(append([]byte(nil), streamID...), opaqueChunk(0x02, 8)...))
+
+	f.Fuzz(func(t *testing.T, enc []byte) {
+		r := NewReader(bytes.NewReader(enc))
+		p := make([]byte, 100)
+		for {
+			_, err := r.Read(p)
+			if r.buf.Len() > maxBlockSize {
+				t.Fatalf("%d bytes buffered", r.buf.Len())
+			}
+			if cap(r.dst) > maxBlockSize {
+				t.Fatalf("dst buffer of %d bytes", cap(r.dst))
+			}
+			if cap(r.src) > int(maxEncodedBlockSize)+4 {
+				t.Fatalf("src buffer of %d bytes", cap(r.src))
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+// FuzzRoundTrip checks that data encoded by a Writer is decoded unchanged by a
+// Reader.  The Writer is flushed after the first split bytes, so that blocks
+// of varying size are written.
+func FuzzRoundTrip(f *testing.F) {
+	f.Add([]byte{}, uint32(0))
+	f.Add([]byte{'a'}, uint32(0))
+	f.Add(bytes.Repeat(testDataJSON, 3)[:MaxBlockSize], uint32(0))
+	for _, n := range []int{MaxBlockSize - 1, MaxBlockSize, MaxBlockSize + 1} {
+		f.Add(bytes.Repeat([]byte{'x'}, n), uint32(0))
+		f.Add(bytes.Repeat(testDataMan, 20)[:n], uint32(1000))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, split uint32) {
+		k := int(split % uint32(len(data)+1))
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		_, err := w.Write(data[:k])
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		err = w.Flush()
+		if err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		_, err = w.Write(data[k:])
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+
+		p, err := ioutil.ReadAll(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("decoded %d bytes differ from %d bytes encoded", len(p), len(data))
+		}
+	})
+}
@@ -0,0 +1,105 @@
+package snappyframed
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The streams in testdata/*.sz are generated by testdata/generate.py, which
+// assembles them chunk by chunk from the framing format description.  The
+// compressed chunk bodies come from a minimal block encoder in the script
+// which shares no code with this package.  It is not the reference
+// compressor, so the streams check decoding of independently constructed
+// input rather than compatibility with the reference implementation.  Each
+// decodes to the matching testdata/*.txt file.
+//
+//	empty  a stream identifier alone
+//	hello  a single uncompressed data chunk
+//	mixed  uncompressed and compressed data chunks, padding, a reserved
+//	       skippable chunk, and a repeated stream identifier
+//
+// To regenerate them run the following from the repository root.
+//
+//	python3 testdata/generate.py
+var goldenStreams = []string{"empty", "hello", "mixed"}
+
+// writerGolden lists the golden streams which a Writer must reproduce byte for
+// byte.  A Writer's output is byte-compatible with any conformant encoder for
+// data written uncompressed, as short incompressible data is, because no
+// choice of encoding is involved.  The mixed stream contains chunks a Writer
+// does not emit.
+var writerGolden = []string{"empty", "hello"}
+
+// This test decodes the golden streams and checks that a Writer reproduces
+// the streams in writerGolden.
+func TestInteropGolden(t *testing.T) {
+	for _, name := range goldenStreams {
+		enc, plain := readGolden(t, name)
+		dec, err := ioutil.ReadAll(NewReader(bytes.NewReader(enc)))
+		if err != nil {
+			t.Errorf("%s: read: %v", name, err)
+		}
+		if !bytes.Equal(dec, plain) {
+			t.Errorf("%s: decoded data differs", name)
+		}
+		n, err := DecodedLen(bytes.NewReader(enc), int64(len(enc)))
+		if err != nil || n != int64(len(plain)) {
+			t.Errorf("%s: decoded length %d: %v", name, n, err)
+		}
+	}
+
+	for _, name := range writerGolden {
+		enc, plain := readGolden(t, name)
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		w.Write(plain)
+		err := w.FlushFrame()
+		if err != nil {
+			t.Fatalf("%s: flush: %v", name, err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("%s: close: %v", name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), enc) {
+			t.Errorf("%s: encoded %x (!= %x)", name, buf.Bytes(), enc)
+		}
+	}
+}
+
+// readGolden returns the golden stream with the given name and its decoded
+// data.
+func readGolden(t *testing.T, name string) (enc, plain []byte) {
+	enc, err := ioutil.ReadFile(filepath.Join("testdata", name+".sz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err = ioutil.ReadFile(filepath.Join("testdata", name+".txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return enc, plain
+}
+
+// This test pins the checksum algorithm: CRC-32C (Castagnoli) followed by the
+// masking function given in the framing format description.
+func TestChecksumMasking(t *testing.T) {
+	if c := crc32.Checksum([]byte("123456789"), crcTable); c != 0xe3069283 {
+		t.Fatalf("crc32c check value %#x", c)
+	}
+	for _, test := range []struct{ c, masked uint32 }{
+		{0, 0xa282ead8},
+		{0xe3069283, 0xc78ab0e5},
+		{0xffffffff, 0xa282ead7},
+	} {
+		if m := maskChecksum(test.c); m != test.masked {
+			t.Errorf("mask %#x: %#x (!= %#x)", test.c, m, test.masked)
+		}
+		if c := unmaskChecksum(test.masked); c != test.c {
+			t.Errorf("unmask %#x: %#x (!= %#x)", test.masked, c, test.c)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package snappyframed
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Header is metadata describing a stream, similar to gzip.Header.  A Header is
+// written by a Writer created with the WriterOptions Header field and read
+// with Reader.Header.
+//
+// A Header is encoded in a reserved skippable chunk (type 0x81) immediately
+// following the stream identifier, so it is ignored by conformant readers.
+// The chunk data has the following layout, in which strings are preceded by
+// their length as an unsigned varint (encoding/binary).
+//
+//	version      1 byte, currently 1
+//	content type uvarint length, UTF-8 bytes
+//	name         uvarint length, UTF-8 bytes
+//	mod time     varint Unix seconds, uvarint nanoseconds
+//
+// Data following these fields is reserved for future versions and ignored.
+// A zero ModTime is encoded as the Unix epoch, and vice versa.
+type Header struct {
+	ContentType string    // media type of the decoded data
+	Name        string    // original file name
+	ModTime     time.Time // modification time
+}
+
+// headerVersion is the version of the encoded Header layout.
+const headerVersion = 1
+
+// maxHeaderSize is the largest encoded Header accepted.
+const maxHeaderSize = maxBlockSize
+
+// errHeaderTooLarge is returned when a Header exceeds maxHeaderSize.
+var errHeaderTooLarge = fmt.Errorf("header too large")
+
+// marshal returns the encoded chunk data for h.
+func (h *Header) marshal() ([]byte, error) {
+	var sec int64
+	var nsec int
+	if !h.ModTime.IsZero() {
+		sec = h.ModTime.Unix()
+		nsec = h.ModTime.Nanosecond()
+	}
+
+	var tmp [binary.MaxVarintLen64]byte
+	p := []byte{headerVersion}
+	p = append(p, tmp[:binary.PutUvarint(tmp[:], uint64(len(h.ContentType)))]...)
+	p = append(p, h.ContentType...)
+	p = append(p, tmp[:binary.PutUvarint(tmp[:], uint64(len(h.Name)))]...)
+	p = append(p, h.Name...)
+	p = append(p, tmp[:binary.PutVarint(tmp[:], sec)]...)
+	p = append(p, tmp[:binary.PutUvarint(tmp[:], uint64(nsec))]...)
+	if len(p) > maxHeaderSize {
+		return nil, errHeaderTooLarge
+	}
+	return p, nil
+}
+
+// parseHeader decodes Header chunk data p.
+func parseHeader(p []byte) (Header, error) {
+	var h Header
+	if len(p) == 0 {
+		return h, fmt.Errorf("empty header")
+	}
+	if p[0] != headerVersion {
+		return h, fmt.Errorf("unsupported header version %d", p[0])
+	}
+	p = p[1:]
+
+	str := func() (string, error) {
+		n, m := binary.Uvarint(p)
+		if m <= 0 || n > uint64(len(p)-m) {
+			return "", fmt.Errorf("malformed header")
+		}
+		s := string(p[m : m+int(n)])
+		p = p[m+int(n):]
+		return s, nil
+	}
+	var err error
+	h.ContentType, err = str()
+	if err != nil {
+		return Header{}, err
+	}
+	h.Name, err = str()
+	if err != nil {
+		return Header{}, err
+	}
+	sec, m := binary.Varint(p)
+	if m <= 0 {
+		return Header{}, fmt.Errorf("malformed header")
+	}
+	p = p[m:]
+	nsec, m := binary.Uvarint(p)
+	if m <= 0 || nsec >= uint64(time.Second) {
+		return Header{}, fmt.Errorf("malformed header")
+	}
+	if sec != 0 || nsec != 0 {
+		h.ModTime = time.Unix(sec, int64(nsec))
+	}
+	return h, nil
+}
+
+// Header returns the Header of the stream being read by sz.  If the stream has
+// no Header a zero Header is returned.  Header must be called before data is
+// read from sz.  Header returns an error if the stream's Header is malformed
+// or if the beginning of the stream cannot be read.
+func (sz *Reader) Header() (Header, error) {
+	err := sz.readPreamble()
+	if err != nil {
+		return Header{}, err
+	}
+	return sz.header, sz.headerErr
+}
+
+// readPreamble reads the chunks preceding the first data block of the stream,
+// if they have not been read already.
+func (sz *Reader) readPreamble() error {
+	if sz.err != nil && sz.err != io.EOF {
+		return sz.err
+	}
+	if !sz.headerDone {
+		err := sz.truncated(sz.nextChunk())
+		if err != nil && err != io.EOF {
+			sz.err = err
+			return err
+		}
+		sz.headerDone = true
+	}
+	return nil
+}
+
+// readStreamHeader reads a Header chunk.  Errors parsing the Header are
+// retained for Reader.Header and do not interrupt decoding.
+func (sz *Reader) readStreamHeader() error {
+	length := decodeLength(sz.hdr[1:])
+	if length > maxHeaderSize {
+		sz.headerErr = errHeaderTooLarge
+		return sz.discardBlock()
+	}
+	if int(length) > len(sz.src) {
+		sz.src = make([]byte, length)
+	}
+	buf := sz.src[:length]
+	err := sz.inspectBlock(buf)
+	if err != nil {
+		return err
+	}
+	sz.header, sz.headerErr = parseHeader(buf)
+	sz.headerDone = true
+	return nil
+}
+
+// writeStreamHeader writes a Header chunk containing data p.
+func (sz *writer) writeStreamHeader(p []byte) error {
+	hdr := sz.hdr[:4]
+	hdr[0] = blockStreamHeader
+	hdr[1] = byte(len(p))
+	hdr[2] = byte(len(p) >> 8)
+	hdr[3] = byte(len(p) >> 16)
+	err := sz.writeFull(hdr)
+	if err != nil {
+		return fmt.Errorf("writing stream header: %w", err)
+	}
+	err = sz.writeFull(p)
+	if err != nil {
+		return fmt.Errorf("writing stream header: %w", err)
+	}
+	sz.stats.Frames++
+	sz.stats.BytesOut += int64(len(hdr) + len(p))
+	sz.trace(blockStreamHeader, len(hdr)+len(p), 0)
+	return nil
+}
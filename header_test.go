@@ -0,0 +1,142 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestHeader(t *testing.T) {
+	hdr := &Header{
+		ContentType: "text/plain",
+		Name:        "hello.txt",
+		ModTime:     time.Unix(1500000000, 12345),
+	}
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{Header: hdr})
+	_, err := w.Write([]byte("hello header"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	h, err := r.Header()
+	if err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if h.ContentType != hdr.ContentType || h.Name != hdr.Name || !h.ModTime.Equal(hdr.ModTime) {
+		t.Fatalf("header: %+v", h)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "hello header" {
+		t.Fatalf("data: %q", p)
+	}
+
+	// readers unaware of headers skip the chunk
+	p, err = ioutil.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("read without header: %v", err)
+	}
+	if string(p) != "hello header" {
+		t.Fatalf("data without header: %q", p)
+	}
+}
+
+// This test checks that a Header chunk is read subject to the skip limit and
+// written to the discard sink like other reserved skippable chunks.
+func TestHeader_discardSink(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{Header: &Header{Name: "sink.txt"}})
+	w.Write([]byte("data"))
+	w.Close()
+	var payload []byte
+	for _, chunk := range splitChunks(t, buf.Bytes()) {
+		if chunk[0] == blockStreamHeader {
+			payload = chunk[4:]
+		}
+	}
+
+	var sink bytes.Buffer
+	var paced []int
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetDiscardSink(&sink, 1<<20)
+	r.SetSkipLimit(3, func(n int) error {
+		paced = append(paced, n)
+		return nil
+	})
+	h, err := r.Header()
+	if err != nil || h.Name != "sink.txt" {
+		t.Fatalf("header: %+v %v", h, err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil || string(p) != "data" {
+		t.Fatalf("read: %q %v", p, err)
+	}
+	if !bytes.Equal(sink.Bytes(), payload) {
+		t.Fatalf("sink: %x (!= %x)", sink.Bytes(), payload)
+	}
+	var total int
+	for _, n := range paced {
+		if n > 3 {
+			t.Fatalf("segment of %d bytes", n)
+		}
+		total += n
+	}
+	if total != len(payload) {
+		t.Fatalf("paced %d bytes (!= %d)", total, len(payload))
+	}
+}
+
+func TestHeader_none(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write([]byte("no header"))
+	w.Close()
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	h, err := r.Header()
+	if err != nil {
+		t.Fatalf("header: %v", err)
+	}
+	if h != (Header{}) {
+		t.Fatalf("header: %+v", h)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "no header" {
+		t.Fatalf("data: %q", p)
+	}
+}
+
+func TestHeader_malformed(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(streamID)
+	buf.Write([]byte{blockStreamHeader, 1, 0, 0, 99})
+	w := NewWriter(&buf)
+	w.w.sentStreamID = true
+	w.Write([]byte("data"))
+	w.Close()
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	_, err := r.Header()
+	if err == nil {
+		t.Fatalf("expected error for unsupported header version")
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "data" {
+		t.Fatalf("data: %q", p)
+	}
+}
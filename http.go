@@ -0,0 +1,379 @@
+package snappyframed
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// readerPool and writerPool hold Readers and Writers reused by the HTTP
+// helpers.
+var readerPool = NewReaderPool()
+var writerPool = NewWriterPool()
+
+// WrapHandler returns an http.Handler that performs snappy framed content
+// negotiation before calling h.
+//
+// If a request's Content-Encoding is ContentEncoding its body is decoded
+// before it is read by h.  The Content-Encoding and Content-Length headers are
+// removed from the request passed to h because they do not describe the
+// decoded body.  The request's Content-Type is left unaltered.
+//
+// If a request's Accept-Encoding header lists ContentEncoding explicitly, with
+// a nonzero quality value, data written to the http.ResponseWriter by h is
+// encoded as a snappy framed stream.  The "*" coding is not sufficient because
+// many clients send it without being able to decode snappy framed streams.
+// The response's Content-Encoding is set to ContentEncoding and any
+// Content-Length set by h is removed.  The Content-Type set by h is kept; if h
+// sets none it is detected from the unencoded data.
+//
+// Every response has "Accept-Encoding" added to its Vary header, including
+// unencoded responses, so that caches distinguish the two.  Requests which do
+// neither of the above are otherwise passed to h unaltered.
+func WrapHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") == ContentEncoding {
+			body := newDecodeBody(r.Body)
+			defer body.release()
+
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.Header = cloneHeader(r.Header)
+			r2.Header.Del("Content-Encoding")
+			r2.Header.Del("Content-Length")
+			r2.ContentLength = -1
+			r2.Body = body
+			r = r2
+		}
+
+		// the response depends on Accept-Encoding whether or not it is
+		// encoded, and caches must not serve one variant in place of the other.
+		resp.Header().Add("Vary", "Accept-Encoding")
+		if acceptsEncoding(r.Header) {
+			w := newResponseWriter(resp)
+			defer w.close()
+			resp = w
+		}
+
+		h.ServeHTTP(resp, r)
+	})
+}
+
+// AcceptsFramed returns true if the Accept header in h permits a response of
+// type MediaType.  The most specific media range matching MediaType is used,
+// so "application/x-snappy-framed;q=0, */*" does not accept MediaType while
+// "*/*" and "application/*" do.  Media ranges with a quality value of zero are
+// not acceptable.  If h has no Accept header AcceptsFramed returns false.
+func AcceptsFramed(h http.Header) bool {
+	q, _ := acceptQuality(h)
+	return q > 0
+}
+
+// SetFramedContentType sets the Content-Type in h to MediaType.
+func SetFramedContentType(h http.Header) {
+	h.Set("Content-Type", MediaType)
+}
+
+// acceptsEncoding returns true if the Accept-Encoding header in h lists
+// ContentEncoding explicitly with a nonzero quality value.
+func acceptsEncoding(h http.Header) bool {
+	for _, accept := range h["Accept-Encoding"] {
+		for _, coding := range strings.Split(accept, ",") {
+			params := strings.Split(coding, ";")
+			if strings.ToLower(strings.TrimSpace(params[0])) != ContentEncoding {
+				continue
+			}
+			q, ok := parseQuality(params[1:])
+			return ok && q > 0
+		}
+	}
+	return false
+}
+
+// acceptQuality returns the quality value given to MediaType by the Accept
+// header in h using the most specific matching media range.  The specificity
+// of the range is 2 for MediaType itself, 1 for "application/*", and 0 for
+// "*/*".  If no range matches, acceptQuality returns a specificity of -1.
+func acceptQuality(h http.Header) (q float64, specificity int) {
+	specificity = -1
+	for _, accept := range h["Accept"] {
+		for _, mrange := range strings.Split(accept, ",") {
+			params := strings.Split(mrange, ";")
+			var s int
+			switch strings.ToLower(strings.TrimSpace(params[0])) {
+			case MediaType:
+				s = 2
+			case "application/*":
+				s = 1
+			case "*/*":
+				s = 0
+			default:
+				continue
+			}
+			if s <= specificity {
+				continue
+			}
+			rangeq, ok := parseQuality(params[1:])
+			if !ok {
+				continue
+			}
+			q, specificity = rangeq, s
+		}
+	}
+	return q, specificity
+}
+
+// parseQuality returns the quality value in media range or content coding
+// params, 1 if there is none.  parseQuality returns false if the quality value
+// is malformed.
+func parseQuality(params []string) (float64, bool) {
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if len(param) < 2 || strings.ToLower(param[:2]) != "q=" {
+			continue
+		}
+		q, err := strconv.ParseFloat(param[2:], 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0, false
+		}
+		return q, true
+	}
+	return 1, true
+}
+
+// Transport returns an http.RoundTripper that performs snappy framed content
+// negotiation for requests made through rt.  If rt is nil
+// http.DefaultTransport is used.
+//
+// Request bodies are encoded as they are sent, with Content-Encoding
+// ContentEncoding and an unknown Content-Length, keeping the request's
+// Content-Type.  Requests without a body, or whose body already has a
+// Content-Encoding, are not encoded.  Responses with Content-Encoding
+// ContentEncoding have their bodies decoded transparently, in which case the
+// response Content-Encoding and Content-Length headers are removed and the
+// response is marked Uncompressed.  Other responses are returned unaltered,
+// allowing servers to ignore the negotiation.
+//
+// ContentEncoding is appended to a request's Accept-Encoding header.  A request
+// without an Accept-Encoding header is sent with "x-snappy-framed, gzip"
+// instead, and gzip compressed responses are decoded transparently as
+// http.Transport would have done had rt not set the header, except for
+// requests with a Range header, for which gzip is not requested.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &transport{rt}
+}
+
+type transport struct {
+	rt http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.Header = cloneHeader(r.Header)
+	var requestedGzip bool
+	if accept := r2.Header.Get("Accept-Encoding"); accept != "" {
+		r2.Header.Set("Accept-Encoding", accept+", "+ContentEncoding)
+	} else if r2.Header.Get("Range") == "" {
+		requestedGzip = true
+		r2.Header.Set("Accept-Encoding", ContentEncoding+", gzip")
+	} else {
+		r2.Header.Set("Accept-Encoding", ContentEncoding)
+	}
+
+	if r.Body != nil && r.Body != http.NoBody && r.Header.Get("Content-Encoding") == "" {
+		r2.Header.Set("Content-Encoding", ContentEncoding)
+		r2.Header.Del("Content-Length")
+		r2.ContentLength = -1
+		r2.Body = encodeBody(r.Body)
+		r2.GetBody = nil
+		if r.GetBody != nil {
+			r2.GetBody = func() (io.ReadCloser, error) {
+				body, err := r.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				return encodeBody(body), nil
+			}
+		}
+	}
+
+	resp, err := t.rt.RoundTrip(r2)
+	if err != nil {
+		return nil, err
+	}
+	switch enc := resp.Header.Get("Content-Encoding"); {
+	case enc == ContentEncoding:
+		resp.Body = &responseBody{newDecodeBody(resp.Body)}
+	case enc == "gzip" && requestedGzip:
+		resp.Body = &gzipBody{body: resp.Body}
+	default:
+		return resp, nil
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// encodeBody returns an io.ReadCloser from which the data read from body is
+// read encoded.  Data is encoded by a pooled Writer as it is read, and body is
+// closed after it has been read in full or the returned io.ReadCloser is
+// closed.
+func encodeBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		sz := writerPool.Get(pw)
+		defer writerPool.Put(sz)
+		_, err := io.Copy(sz, body)
+		if err == nil {
+			err = sz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// gzipBody is an io.ReadCloser that decodes a gzip compressed HTTP body.  The
+// gzip header is not read until the first call to Read.
+type gzipBody struct {
+	body io.ReadCloser
+	zr   *gzip.Reader
+	err  error
+}
+
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.zr == nil && b.err == nil {
+		b.zr, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.zr.Read(p)
+}
+
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
+
+// cloneHeader returns a shallow copy of h.
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = v
+	}
+	return h2
+}
+
+// decodeBody is an io.ReadCloser that decodes a snappy framed HTTP body using
+// a pooled Reader.
+type decodeBody struct {
+	body io.ReadCloser
+	sz   *Reader
+}
+
+func newDecodeBody(body io.ReadCloser) *decodeBody {
+	sz := readerPool.Get(body)
+	return &decodeBody{
+		body: body,
+		sz:   sz,
+	}
+}
+
+func (b *decodeBody) Read(p []byte) (int, error) {
+	if b.sz == nil {
+		return 0, errClosed
+	}
+	return b.sz.Read(p)
+}
+
+// Close closes the underlying body.  The pooled Reader is not released until
+// release is called.
+func (b *decodeBody) Close() error {
+	return b.body.Close()
+}
+
+// release returns the pooled Reader.  After release is called Read returns an
+// error.
+func (b *decodeBody) release() {
+	if b.sz == nil {
+		return
+	}
+	readerPool.Put(b.sz)
+	b.sz = nil
+}
+
+// responseBody is a decodeBody that releases its pooled Reader when closed.
+type responseBody struct {
+	*decodeBody
+}
+
+func (b *responseBody) Close() error {
+	err := b.decodeBody.Close()
+	b.release()
+	return err
+}
+
+// responseWriter is an http.ResponseWriter that encodes the response body as
+// a snappy framed stream using a pooled Writer.
+type responseWriter struct {
+	http.ResponseWriter
+	sz          *Writer
+	wroteHeader bool
+}
+
+func newResponseWriter(resp http.ResponseWriter) *responseWriter {
+	sz := writerPool.Get(resp)
+	return &responseWriter{
+		ResponseWriter: resp,
+		sz:             sz,
+	}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	h.Set("Content-Encoding", ContentEncoding)
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		// The http package would detect the type of the encoded data.
+		if _, ok := w.Header()["Content-Type"]; !ok {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.sz.Write(p)
+}
+
+// Flush implements http.Flusher.  Buffered data is encoded and written to the
+// client immediately if the underlying http.ResponseWriter supports flushing.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.sz.Flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close flushes any buffered data and returns the pooled Writer.
+func (w *responseWriter) close() {
+	w.sz.Close()
+	writerPool.Put(w.sz)
+	w.sz = nil
+}
@@ -0,0 +1,421 @@
+package snappyframed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testAPIRequest struct {
+	Name string
+}
+
+type testAPIResponse struct {
+	Messages []string
+}
+
+// testAPIHandler reads a JSON testAPIRequest and writes a JSON
+// testAPIResponse.  It has no knowledge of snappy framed streams.
+func testAPIHandler(resp http.ResponseWriter, r *http.Request) {
+	var req *testAPIRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(resp, "invalid request", http.StatusBadRequest)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(testAPIResponse{
+		Messages: []string{
+			fmt.Sprintf("hello %s", req.Name),
+		},
+	})
+}
+
+// This test mirrors Example_pool but performs content negotiation using
+// WrapHandler.
+func TestWrapHandler(t *testing.T) {
+	server := httptest.NewServer(WrapHandler(http.HandlerFunc(testAPIHandler)))
+	defer server.Close()
+
+	var reqbuf bytes.Buffer
+	w := NewWriter(&reqbuf)
+	json.NewEncoder(w).Encode(testAPIRequest{"middleware"})
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", server.URL, &reqbuf)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", ContentEncoding)
+	req.Header.Set("Accept-Encoding", "gzip, "+ContentEncoding)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %s", resp.Status)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("content type: %q", resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Content-Encoding") != ContentEncoding {
+		t.Fatalf("content encoding: %q", resp.Header.Get("Content-Encoding"))
+	}
+	if resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("vary: %q", resp.Header["Vary"])
+	}
+	p, err := ioutil.ReadAll(NewReader(resp.Body))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	expect := `{"Messages":["hello middleware"]}` + "\n"
+	if string(p) != expect {
+		t.Fatalf("response: %q", p)
+	}
+}
+
+// This test checks that WrapHandler does not alter requests which do not
+// opt into snappy framed encoding.
+func TestWrapHandler_noop(t *testing.T) {
+	server := httptest.NewServer(WrapHandler(http.HandlerFunc(testAPIHandler)))
+	defer server.Close()
+
+	reqbody := bytes.NewBufferString(`{"Name":"plain"}`)
+	resp, err := http.Post(server.URL, "application/json", reqbody)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %s", resp.Status)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("content type: %q", resp.Header.Get("Content-Type"))
+	}
+	if resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("vary: %q", resp.Header["Vary"])
+	}
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	expect := `{"Messages":["hello plain"]}` + "\n"
+	if string(p) != expect {
+		t.Fatalf("response: %q", p)
+	}
+}
+
+// This test checks that WrapHandler detects the Content-Type of the unencoded
+// response when the handler does not set one.
+func TestWrapHandler_detectContentType(t *testing.T) {
+	server := httptest.NewServer(WrapHandler(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		io.WriteString(resp, "<html><body>hello</body></html>")
+	})))
+	defer server.Close()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	req.Header.Set("Accept-Encoding", ContentEncoding)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != ContentEncoding {
+		t.Fatalf("content encoding: %q", resp.Header.Get("Content-Encoding"))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Fatalf("content type: %q", ct)
+	}
+}
+
+// This test checks that a client using Transport communicates with a server
+// using WrapHandler.
+func TestTransport_wrapHandler(t *testing.T) {
+	var encoded bool
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		encoded = r.Header.Get("Content-Encoding") == ContentEncoding && acceptsEncoding(r.Header)
+		WrapHandler(http.HandlerFunc(testAPIHandler)).ServeHTTP(resp, r)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	resp, err := client.Post(server.URL, "application/json", bytes.NewBufferString(`{"Name":"roundtrip"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if !encoded {
+		t.Fatalf("request not encoded")
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %s", resp.Status)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("content type: %q", resp.Header.Get("Content-Type"))
+	}
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	expect := `{"Messages":["hello roundtrip"]}` + "\n"
+	if string(p) != expect {
+		t.Fatalf("response: %q", p)
+	}
+}
+
+// This test checks that Transport encodes request bodies and decodes response
+// bodies using Content-Encoding, preserving their Content-Type.
+func TestTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), ContentEncoding) {
+			http.Error(resp, "encoding not accepted", http.StatusBadRequest)
+			return
+		}
+		if r.Header.Get("Content-Encoding") != ContentEncoding || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(resp, "request not encoded", http.StatusBadRequest)
+			return
+		}
+		var req testAPIRequest
+		err := json.NewDecoder(NewReader(r.Body)).Decode(&req)
+		if err != nil {
+			http.Error(resp, "invalid request", http.StatusBadRequest)
+			return
+		}
+		resp.Header().Set("Content-Type", "application/json")
+		resp.Header().Set("Content-Encoding", ContentEncoding)
+		w := NewWriter(resp)
+		json.NewEncoder(w).Encode(testAPIResponse{
+			Messages: []string{fmt.Sprintf("hello %s", req.Name)},
+		})
+		w.Close()
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	resp, err := client.Post(server.URL, "application/json", bytes.NewBufferString(`{"Name":"transport"}`))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status: %s", resp.Status)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("response headers: %q", resp.Header)
+	}
+	if !resp.Uncompressed {
+		t.Fatalf("response not marked uncompressed")
+	}
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	expect := `{"Messages":["hello transport"]}` + "\n"
+	if string(p) != expect {
+		t.Fatalf("response: %q", p)
+	}
+}
+
+// This test checks that Transport keeps requesting and decoding gzip
+// compressed responses unless the caller sets Accept-Encoding.
+func TestTransport_gzip(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		resp.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		resp.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(resp)
+		io.WriteString(zw, "gzip response")
+		zw.Close()
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	for _, test := range []struct {
+		accept   string
+		sent     string
+		decoded  bool
+		encoding string
+	}{
+		{"", ContentEncoding + ", gzip", true, ""},
+		{"br", "br, " + ContentEncoding, false, "gzip"},
+	} {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		if test.accept != "" {
+			req.Header.Set("Accept-Encoding", test.accept)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%q: read: %v", test.accept, err)
+		}
+		if sent := resp.Header.Get("X-Accept-Encoding"); sent != test.sent {
+			t.Errorf("%q: accept encoding %q (!= %q)", test.accept, sent, test.sent)
+		}
+		if enc := resp.Header.Get("Content-Encoding"); enc != test.encoding {
+			t.Errorf("%q: content encoding %q", test.accept, enc)
+		}
+		if (string(p) == "gzip response") != test.decoded || resp.Uncompressed != test.decoded {
+			t.Errorf("%q: response %q (uncompressed %t)", test.accept, p, resp.Uncompressed)
+		}
+	}
+}
+
+// This test checks that Transport encodes the request body again when a
+// request is redirected.
+func TestTransport_redirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusTemporaryRedirect))
+	mux.HandleFunc("/new", func(resp http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != ContentEncoding || r.ContentLength != -1 {
+			http.Error(resp, "request not encoded", http.StatusBadRequest)
+			return
+		}
+		io.Copy(resp, NewReader(r.Body))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	resp, err := client.Post(server.URL+"/old", "text/plain", bytes.NewBufferString("redirected"))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || string(p) != "redirected" {
+		t.Fatalf("response: %s %q", resp.Status, p)
+	}
+}
+
+// This test checks that Transport does not encode requests without a body.
+func TestTransport_noBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" || r.ContentLength != 0 {
+			http.Error(resp, "request body encoded", http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	for _, body := range []io.Reader{nil, http.NoBody} {
+		req, err := http.NewRequest("POST", server.URL, body)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("body %v: status: %s", body, resp.Status)
+		}
+	}
+}
+
+// This test checks that Transport passes through responses from servers which
+// ignore snappy framed content negotiation.
+func TestTransport_plainResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(resp http.ResponseWriter, r *http.Request) {
+		resp.Header().Set("Content-Type", "text/plain")
+		resp.Write([]byte("plain response"))
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: Transport(nil)}
+	resp, err := client.Post(server.URL, "text/plain", bytes.NewBufferString("plain request"))
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("content type: %q", resp.Header.Get("Content-Type"))
+	}
+	p, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "plain response" {
+		t.Fatalf("response: %q", p)
+	}
+}
+
+func TestAcceptsFramed(t *testing.T) {
+	for _, test := range []struct {
+		accept  []string
+		accepts bool
+	}{
+		{nil, false},
+		{[]string{"application/json"}, false},
+		{[]string{MediaType}, true},
+		{[]string{"application/json", MediaType}, true},
+		{[]string{"application/json, " + MediaType + ";q=0.5"}, true},
+		{[]string{MediaType + ";q=0.5, */*"}, true},
+		{[]string{MediaType + "; q=0, */*"}, false},
+		{[]string{"*/*;q=0, " + MediaType}, true},
+		{[]string{"*/*"}, true},
+		{[]string{"application/*;q=0.1"}, true},
+		{[]string{"application/*;q=0, */*"}, false},
+		{[]string{"text/html, */*;q=0.8"}, true},
+		{[]string{MediaType + ";q=bogus"}, false},
+	} {
+		h := http.Header{"Accept": test.accept}
+		if AcceptsFramed(h) != test.accepts {
+			t.Errorf("%q: accepts %t", test.accept, !test.accepts)
+		}
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	for _, test := range []struct {
+		accept  []string
+		accepts bool
+	}{
+		{nil, false},
+		{[]string{"gzip"}, false},
+		{[]string{"*"}, false},
+		{[]string{ContentEncoding}, true},
+		{[]string{"gzip", ContentEncoding}, true},
+		{[]string{"gzip, " + ContentEncoding + ";q=0.5"}, true},
+		{[]string{"gzip, X-Snappy-Framed"}, true},
+		{[]string{ContentEncoding + "; q=0, *"}, false},
+		{[]string{ContentEncoding + ";q=bogus"}, false},
+	} {
+		h := http.Header{"Accept-Encoding": test.accept}
+		if acceptsEncoding(h) != test.accepts {
+			t.Errorf("%q: accepts %t", test.accept, !test.accepts)
+		}
+	}
+}
+
+func TestSetFramedContentType(t *testing.T) {
+	h := http.Header{"Content-Type": {"application/json"}}
+	SetFramedContentType(h)
+	if h.Get("Content-Type") != MediaType {
+		t.Fatalf("content type: %q", h["Content-Type"])
+	}
+}
@@ -0,0 +1,104 @@
+package snappyframed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// errMessageBoundary is returned internally when a Reader reading with
+// NextMessage encounters a flush marker.
+var errMessageBoundary = fmt.Errorf("message boundary")
+
+// WriteMessages writes msgs to sz so that message boundaries can be recovered
+// by Reader.ReadMessage.  Each message is preceded in the decoded stream by
+// its length, encoded as an unsigned varint (encoding/binary).  Messages are
+// buffered like any other data written to sz and packed together into blocks
+// of up to MaxBlockSize bytes, which compress better than blocks containing
+// individually flushed messages.  A message may span blocks.
+//
+// WriteMessages returns the number of messages written along with any error
+// encountered.  Callers must call Flush or Close to guarantee all messages
+// have been written to the underlying io.Writer.
+func (sz *Writer) WriteMessages(msgs [][]byte) (int, error) {
+	var lenbuf [binary.MaxVarintLen64]byte
+	for i, msg := range msgs {
+		n := binary.PutUvarint(lenbuf[:], uint64(len(msg)))
+		_, err := sz.Write(lenbuf[:n])
+		if err != nil {
+			return i, err
+		}
+		_, err = sz.Write(msg)
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// ReadMessage returns the next message in a stream written with
+// Writer.WriteMessages.  ReadMessage returns io.EOF when the stream ends
+// cleanly between messages and io.ErrUnexpectedEOF if the stream ends
+// within a message.  The returned slice is not retained by sz.
+func (sz *Reader) ReadMessage() ([]byte, error) {
+	// binary.ReadUvarint returns io.EOF only if no bytes were read.
+	length, err := binary.ReadUvarint(sz)
+	if err != nil {
+		return nil, err
+	}
+
+	if length > math.MaxInt64 {
+		return nil, fmt.Errorf("message too large %d", length)
+	}
+
+	// copy the message instead of allocating length bytes up front so that a
+	// corrupt length cannot cause a large allocation.
+	var buf bytes.Buffer
+	_, err = noeof64(io.CopyN(&buf, sz, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NextMessage returns the data decoded from the stream up to the next flush
+// marker, that is, all data written to a Writer created with the FlushMarkers
+// option between two calls to Flush.  Unlike ReadMessage no length prefix is
+// required, but message boundaries are lost if the producer flushes without
+// markers, in which case the remainder of the stream is returned as a single
+// message.  The data of the final message is returned even if the producer
+// did not flush it before closing the stream.  Empty messages are skipped.
+//
+// The returned slice is only valid until the next call to a method of sz.  If
+// data remains buffered from a previous call to Read it is returned as the
+// beginning of the message.  NextMessage returns io.EOF after all messages
+// have been read.
+func (sz *Reader) NextMessage() ([]byte, error) {
+	if sz.err != nil {
+		return nil, sz.err
+	}
+
+	sz.msg = append(sz.msg[:0], sz.buf.Next(sz.buf.Len())...)
+	sz.stopAtMarker = true
+	defer func() { sz.stopAtMarker = false }()
+	for {
+		var w frameWriter
+		_, err := sz.nextFrame(&w)
+		switch {
+		case err == errMessageBoundary:
+			if len(sz.msg) > 0 {
+				return sz.msg, nil
+			}
+			continue
+		case err == io.EOF && len(sz.msg) > 0:
+			sz.err = err
+			return sz.msg, nil
+		case err != nil:
+			sz.err = err
+			return nil, err
+		}
+		sz.msg = append(sz.msg, w.p...)
+	}
+}
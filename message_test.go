@@ -0,0 +1,209 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// This test checks that message boundaries survive a round trip through
+// WriteMessages and ReadMessage.
+func TestWriteMessages(t *testing.T) {
+	msgs := [][]byte{
+		[]byte("hello"),
+		{},
+		[]byte("snappystream"),
+		bytes.Repeat([]byte("a message spanning blocks "), maxBlockSize/10),
+		[]byte("goodbye"),
+	}
+	var small [][]byte
+	for i := 0; i < 1000; i++ {
+		small = append(small, []byte("a tiny message"))
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	n, err := w.WriteMessages(msgs)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if n != len(msgs) {
+		t.Fatalf("wrote %d messages (!= %d)", n, len(msgs))
+	}
+	n, err = w.WriteMessages(small)
+	if err != nil {
+		t.Fatalf("write small: %v", err)
+	}
+	if n != len(small) {
+		t.Fatalf("wrote %d small messages (!= %d)", n, len(small))
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	enc := buf.Bytes()
+	r := NewReader(bytes.NewReader(enc))
+	for i, expect := range append(msgs, small...) {
+		msg, err := r.ReadMessage()
+		if err != nil {
+			t.Fatalf("read message %d: %v", i, err)
+		}
+		if !bytes.Equal(msg, expect) {
+			t.Fatalf("read message %d: unexpected content %q", i, msg)
+		}
+	}
+	_, err = r.ReadMessage()
+	if err != io.EOF {
+		t.Fatalf("read after last message: %v", err)
+	}
+
+	// small messages are packed into a single block
+	w = NewWriter(&buf)
+	_, err = w.WriteMessages(small)
+	if err != nil {
+		t.Fatalf("write small: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if w.Stats().BlocksTotal != 1 {
+		t.Errorf("messages were not packed: %d blocks", w.Stats().BlocksTotal)
+	}
+
+	// a stream truncated within a message
+	var trunc bytes.Buffer
+	w = NewWriter(&trunc)
+	w.Write([]byte{10, 'a'})
+	w.Close()
+	r = NewReader(&trunc)
+	_, err = r.ReadMessage()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("read truncated message: %v", err)
+	}
+}
+
+// This test checks that data flushed by a Writer with FlushMarkers is read as
+// individual messages by NextMessage.
+func TestNextMessage(t *testing.T) {
+	large := bytes.Repeat([]byte("a message spanning blocks "), maxBlockSize/10)
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{FlushMarkers: true})
+	for _, parts := range [][]string{
+		{"one"},
+		{"two", "three"},
+		{},
+		{string(large)},
+	} {
+		for _, p := range parts {
+			_, err := w.Write([]byte(p))
+			if err != nil {
+				t.Fatalf("write: %v", err)
+			}
+		}
+		err := w.Flush()
+		if err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+	}
+	_, err := w.Write([]byte("unflushed"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	for i, expect := range []string{"one", "twothree", string(large), "unflushed"} {
+		msg, err := r.NextMessage()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if string(msg) != expect {
+			t.Fatalf("message %d: %d bytes (!= %d)", i, len(msg), len(expect))
+		}
+	}
+	_, err = r.NextMessage()
+	if err != io.EOF {
+		t.Fatalf("end of stream: %v", err)
+	}
+
+	// readers unaware of markers decode the concatenated messages.
+	p, err := ioutil.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "onetwothree"+string(large)+"unflushed" {
+		t.Fatalf("decoded %d bytes", len(p))
+	}
+}
+
+// This test checks that padding written by PadTo and CloseAligned to a Writer
+// with FlushMarkers is not mistaken for a flush marker, even when only a
+// chunk header's worth of padding is needed.
+func TestNextMessage_padding(t *testing.T) {
+	opts := &WriterOptions{FlushMarkers: true}
+
+	// the unpadded stream determines the alignments which require exactly 4
+	// bytes of padding.
+	var ref bytes.Buffer
+	w := NewWriterOptions(&ref, opts)
+	w.Write([]byte("one"))
+	w.Flush()
+	w.Flush()
+	w.Write([]byte("two"))
+	w.Flush()
+	flushed := ref.Len()
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	closing := ref.Len() - flushed
+
+	var buf bytes.Buffer
+	w = NewWriterOptions(&buf, opts)
+	w.Write([]byte("one"))
+	w.Flush()
+	err = w.PadTo(buf.Len() + 4)
+	if err != nil {
+		t.Fatalf("pad: %v", err)
+	}
+	w.Write([]byte("two"))
+	w.Flush()
+	err = w.CloseAligned(buf.Len() + closing + 4)
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	countMarkers := func(stream []byte) int {
+		var n int
+		for _, chunk := range splitChunks(t, stream) {
+			if bytes.Equal(chunk, flushMarker) {
+				n++
+			}
+		}
+		return n
+	}
+	if n, expect := countMarkers(buf.Bytes()), countMarkers(ref.Bytes()); n != expect {
+		t.Fatalf("%d flush markers (!= %d)", n, expect)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	for i, expect := range []string{"one", "two"} {
+		msg, err := r.NextMessage()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if string(msg) != expect {
+			t.Fatalf("message %d: %q (!= %q)", i, msg, expect)
+		}
+	}
+	_, err = r.NextMessage()
+	if err != io.EOF {
+		t.Fatalf("end of stream: %v", err)
+	}
+}
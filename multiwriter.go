@@ -0,0 +1,51 @@
+package snappyframed
+
+import (
+	"fmt"
+	"io"
+)
+
+// NewMultiWriter returns a Writer that encodes data once and writes each
+// chunk of the resulting stream to every writer in ws, in order.  Writing
+// stops at the first error returned by a writer in ws, which is reported as a
+// *SinkError identifying the writer.  Writers preceding the failed writer in
+// ws will have received the failed chunk.
+func NewMultiWriter(ws ...io.Writer) *Writer {
+	sinks := make([]io.Writer, len(ws))
+	copy(sinks, ws)
+	return NewWriter(&multiWriter{sinks})
+}
+
+// SinkError is returned by a Writer created with NewMultiWriter when writing
+// to one of its writers fails.
+type SinkError struct {
+	Index int   // index of the writer given to NewMultiWriter
+	Err   error // error returned by the writer
+}
+
+func (err *SinkError) Error() string {
+	return fmt.Sprintf("writer %d: %v", err.Index, err.Err)
+}
+
+// Unwrap returns err.Err.
+func (err *SinkError) Unwrap() error {
+	return err.Err
+}
+
+// multiWriter is like io.MultiWriter but identifies the writer which failed.
+type multiWriter struct {
+	ws []io.Writer
+}
+
+func (w *multiWriter) Write(p []byte) (int, error) {
+	for i, sink := range w.ws {
+		n, err := sink.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return 0, &SinkError{Index: i, Err: err}
+		}
+	}
+	return len(p), nil
+}
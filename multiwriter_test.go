@@ -0,0 +1,50 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestMultiWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("tee "), 50000)
+	var a, b bytes.Buffer
+	w := NewMultiWriter(&a, &b)
+	_, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if !bytes.Equal(a.Bytes(), b.Bytes()) {
+		t.Fatalf("streams differ")
+	}
+	p, err := ioutil.ReadAll(NewReader(&a))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func TestMultiWriter_error(t *testing.T) {
+	errFail := errors.New("sink failed")
+	var a bytes.Buffer
+	w := NewMultiWriter(&a, &writerFailN{n: 2, err: errFail}, ioutil.Discard)
+	_, err := w.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	var serr *SinkError
+	if !errors.As(err, &serr) {
+		t.Fatalf("close: %v", err)
+	}
+	if serr.Index != 1 || !errors.Is(err, errFail) {
+		t.Fatalf("close: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package snappyframed
+
+import "io"
+
+// EncodeReader returns a reader from which a snappy framed encoding of the
+// data read from r can be read.  Data is encoded lazily by a goroutine as the
+// returned reader is read.  Errors reading r or encoding its data are returned
+// by the returned reader.
+//
+// The returned reader implements io.Closer.  A caller that does not read the
+// returned reader until it returns an error must close it to release the
+// encoding goroutine.
+func EncodeReader(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		sz := NewWriter(pw)
+		_, err := sz.ReadFrom(r)
+		if err == nil {
+			err = sz.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// DecodeReader returns a reader from which the data decoded from the snappy
+// framed stream r can be read.  Data is decoded lazily by a goroutine as the
+// returned reader is read.  Errors reading or decoding r are returned by the
+// returned reader.
+//
+// The returned reader implements io.Closer.  A caller that does not read the
+// returned reader until it returns an error must close it to release the
+// decoding goroutine.
+func DecodeReader(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := NewReader(r).WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
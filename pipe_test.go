@@ -0,0 +1,62 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEncodeDecodeReader(t *testing.T) {
+	for _, n := range []int{0, 1, 1000, 3*MaxBlockSize + 17} {
+		orig := make([]byte, n)
+		for i := range orig {
+			orig[i] = byte(i % 253)
+		}
+		p, err := ioutil.ReadAll(DecodeReader(EncodeReader(bytes.NewReader(orig))))
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(p, orig) {
+			t.Fatalf("%d bytes: decoded data differs", n)
+		}
+	}
+}
+
+func TestEncodeReader_error(t *testing.T) {
+	errSource := errors.New("source failure")
+	r := io.MultiReader(bytes.NewReader([]byte("partial")), &errReader{errSource})
+	_, err := ioutil.ReadAll(EncodeReader(r))
+	if err != errSource {
+		t.Fatalf("expected %v: %v", errSource, err)
+	}
+}
+
+func TestDecodeReader_error(t *testing.T) {
+	_, err := ioutil.ReadAll(DecodeReader(bytes.NewReader([]byte("not a stream"))))
+	if err == nil {
+		t.Fatalf("expected error decoding invalid stream")
+	}
+}
+
+func TestDecodeReader_close(t *testing.T) {
+	r := DecodeReader(EncodeReader(bytes.NewReader(make([]byte, 4*MaxBlockSize))))
+	var buf [10]byte
+	_, err := io.ReadFull(r, buf[:])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	err = r.(io.Closer).Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	_, err = r.Read(buf[:])
+	if err != io.ErrClosedPipe {
+		t.Fatalf("read after close: %v", err)
+	}
+}
+
+type errReader struct{ err error }
+
+func (r *errReader) Read([]byte) (int, error) { return 0, r.err }
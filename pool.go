@@ -0,0 +1,74 @@
+package snappyframed
+
+import (
+	"io"
+	"sync"
+)
+
+// ReaderPool is a pool of Readers, which avoids the allocation of new Readers
+// and their internal buffers in applications decoding many streams.  A
+// ReaderPool is safe for concurrent use by multiple goroutines.
+//
+// Settings changed on a Reader obtained from a pool are retained when it is
+// returned to the pool, as they are by Reset.  Readers taken from the same
+// pool should be configured identically, or not at all.
+type ReaderPool struct {
+	pool sync.Pool
+}
+
+// NewReaderPool returns an empty ReaderPool.
+func NewReaderPool() *ReaderPool {
+	return &ReaderPool{
+		pool: sync.Pool{New: func() interface{} { return NewReader(nil) }},
+	}
+}
+
+// Get returns a Reader, taken from the pool if one is available, which reads
+// a new stream from r as if it had been Reset.
+func (p *ReaderPool) Get(r io.Reader) *Reader {
+	sz := p.pool.Get().(*Reader)
+	sz.Reset(r)
+	return sz
+}
+
+// Put returns sz to the pool.  Unread data is discarded and sz releases its
+// underlying reader, which is not closed.  sz must not be used after Put.
+func (p *ReaderPool) Put(sz *Reader) {
+	sz.Reset(nil)
+	p.pool.Put(sz)
+}
+
+// WriterPool is a pool of Writers, which avoids the allocation of new Writers
+// and their internal buffers in applications encoding many streams.  A
+// WriterPool is safe for concurrent use by multiple goroutines.
+//
+// Settings changed on a Writer obtained from a pool are retained when it is
+// returned to the pool, as they are by Reset.  Writers taken from the same
+// pool should be configured identically, or not at all.
+type WriterPool struct {
+	pool sync.Pool
+}
+
+// NewWriterPool returns an empty WriterPool.
+func NewWriterPool() *WriterPool {
+	return &WriterPool{
+		pool: sync.Pool{New: func() interface{} { return NewWriter(nil) }},
+	}
+}
+
+// Get returns a Writer, taken from the pool if one is available, which writes
+// a new stream to w as if it had been Reset.
+func (p *WriterPool) Get(w io.Writer) *Writer {
+	sz := p.pool.Get().(*Writer)
+	sz.Reset(w)
+	return sz
+}
+
+// Put returns sz to the pool.  The caller must Close sz before calling Put
+// unless the stream is to be abandoned, because buffered data is discarded.
+// sz releases its underlying writer, which is not closed.  sz must not be
+// used after Put.
+func (p *WriterPool) Put(sz *Writer) {
+	sz.Reset(nil)
+	p.pool.Put(sz)
+}
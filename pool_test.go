@@ -0,0 +1,86 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// This test checks that a Reader taken from a pool does not retain any state
+// from the stream read by its previous user.
+func TestReaderPool(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	pool := NewReaderPool()
+	for i := 0; i < 3; i++ {
+		// leave decoded data buffered and the stream identifier seen.
+		r := pool.Get(bytes.NewReader(enc))
+		_, err := r.Read(make([]byte, 10))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		pool.Put(r)
+		if r.Underlying() != nil {
+			t.Fatalf("underlying reader retained by Put")
+		}
+
+		r = pool.Get(bytes.NewReader(enc))
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("decoded data differs")
+		}
+		pool.Put(r)
+
+		// a stream without an identifier is rejected.
+		r = pool.Get(bytes.NewReader(enc[len(streamID):]))
+		_, err = ioutil.ReadAll(r)
+		if err != errMissingStreamID {
+			t.Fatalf("missing identifier: %v", err)
+		}
+		pool.Put(r)
+	}
+}
+
+// This test checks that a Writer taken from a pool writes a complete stream
+// regardless of how its previous user left it.
+func TestWriterPool(t *testing.T) {
+	pool := NewWriterPool()
+	for i := 0; i < 3; i++ {
+		// abandon a stream with buffered data.
+		var discarded bytes.Buffer
+		w := pool.Get(&discarded)
+		w.Write([]byte("abandoned"))
+		err := w.Flush()
+		if err != nil {
+			t.Fatalf("flush: %v", err)
+		}
+		w.Write([]byte("unflushed"))
+		pool.Put(w)
+
+		var buf bytes.Buffer
+		w = pool.Get(&buf)
+		w.Write([]byte("data"))
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		pool.Put(w)
+		if !bytes.HasPrefix(buf.Bytes(), streamID) {
+			t.Fatalf("stream does not begin with an identifier")
+		}
+		p, err := ioutil.ReadAll(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if string(p) != "data" {
+			t.Fatalf("read: %q", p)
+		}
+	}
+}
@@ -3,9 +3,11 @@ package snappyframed
 import (
 	"bytes"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"reflect"
 
 	"github.com/golang/snappy"
 )
@@ -15,24 +17,220 @@ import (
 // signifies that the source byte stream is not snappy framed.
 var errMissingStreamID = fmt.Errorf("missing stream identifier")
 
+// ErrStreamChecksum is returned by a Reader configured with
+// VerifyStreamChecksum when a stream checksum does not match the decoded data.
+var ErrStreamChecksum = fmt.Errorf("stream checksum does not match")
+
+// errMissingStreamChecksum is returned by a Reader configured with
+// VerifyStreamChecksum when decoded data is not followed by a stream checksum.
+var errMissingStreamChecksum = fmt.Errorf("missing stream checksum")
+
+// ErrBlockExpansion is returned by a Reader when a compressed block would
+// decode to more than the multiple of its compressed size set with
+// SetMaxBlockExpansion.
+var ErrBlockExpansion = fmt.Errorf("block expansion exceeds limit")
+
+// ErrBlockTooLarge is returned, wrapped with the offending length, when a
+// chunk is longer than the largest encoded data block, a data block decodes to
+// more than MaxBlockSize bytes, or a block to be encoded is longer than
+// MaxBlockSize.
+var ErrBlockTooLarge = fmt.Errorf("block too large")
+
+// ErrEmptyBlock is returned when a data block does not contain both a checksum
+// and at least one byte of block data.
+var ErrEmptyBlock = fmt.Errorf("data block has no data")
+
+// ErrDecodedLength is returned by a Reader when the data decoded from a
+// compressed block does not have the length declared by the block.
+var ErrDecodedLength = fmt.Errorf("decoded block length does not match declared length")
+
+// ErrReaderClosed is returned by Reader methods called after Close.
+var ErrReaderClosed = fmt.Errorf("reader closed")
+
+// ErrDecodedLimitExceeded is returned by a Reader when decoding the next block
+// would cause the total number of decoded bytes to exceed the limit set with
+// SetMaxDecodedBytes.
+var ErrDecodedLimitExceeded = fmt.Errorf("decoded data exceeds limit")
+
+// ErrChecksumMismatch is returned by a Reader, wrapped in a *ChecksumError,
+// when the checksum of a data block does not match its decoded data.
+var ErrChecksumMismatch = fmt.Errorf("checksum does not match")
+
+// ChecksumError describes a data block whose checksum does not match its
+// decoded data.  It identifies the chunk containing the block by its byte
+// range in the encoded stream.
+type ChecksumError struct {
+	Offset     int64  // offset of the chunk in the encoded stream
+	Length     int64  // length of the chunk, including its header
+	Compressed bool   // the block is compressed
+	DecodedLen int    // length of the block's decoded data
+	Checksum   uint32 // checksum stored in the block
+	Actual     uint32 // checksum of the decoded data
+}
+
+func (err *ChecksumError) Error() string {
+	typ := "uncompressed"
+	if err.Compressed {
+		typ = "compressed"
+	}
+	return fmt.Sprintf("%v %x != %x (%s block at bytes %d-%d, %d bytes decoded)",
+		ErrChecksumMismatch, err.Checksum, err.Actual, typ,
+		err.Offset, err.Offset+err.Length, err.DecodedLen)
+}
+
+// Unwrap returns ErrChecksumMismatch.
+func (err *ChecksumError) Unwrap() error {
+	return ErrChecksumMismatch
+}
+
+// ErrLengthMismatch is returned by a Reader when the length of the decoded
+// stream differs from the length set with ExpectDecodedLen.
+var ErrLengthMismatch = fmt.Errorf("decoded length does not match expected length")
+
+// ErrEmptyInput is returned by a Reader configured with RejectEmptyInput when
+// the underlying reader ends before a stream identifier has been read.
+var ErrEmptyInput = fmt.Errorf("empty input")
+
+// ErrNonDataChunkLimit is returned by a Reader when more consecutive chunks
+// without data are read than allowed by SetMaxNonDataChunks.
+var ErrNonDataChunkLimit = fmt.Errorf("too many consecutive non-data chunks")
+
 // Reader is an io.Reader that can reads data decompressed from a compressed
 // snappy framed stream read with an underlying io.Reader.
+//
+// A stream ends cleanly, with io.EOF, only when the underlying reader reaches
+// EOF at a chunk boundary.  Padding and reserved skippable chunks may follow
+// the final data block and are discarded.  A reserved unskippable chunk
+// following the final data block causes an error identifying the chunk after
+// all preceding data has been read.  A chunk truncated by EOF causes
+// io.ErrUnexpectedEOF.
+//
+// Empty input is read as an empty stream, because a Writer to which no data is
+// written writes nothing, and Read returns io.EOF just as it does for a stream
+// identifier followed by EOF.  RejectEmptyInput distinguishes the two cases.
 type Reader struct {
 	reader io.Reader
 
 	err error
 
 	seenStreamID bool
+	streamEnd    bool      // a stream identifier ended the stream (see Multistream)
+	nextStream   io.Reader // reader from which streamEnd's identifier was read
+	decoded      int64     // total number of bytes decoded from the stream
+	chunkOffset  int64     // offset of the current chunk in the encoded stream
+	nextOffset   int64     // offset of the chunk following the current chunk
+	discarded    int64     // number of bytes written to opts.discardSink
+	nonData      int       // consecutive chunks read without a data block
+
+	streamCRC        uint32 // unmasked checksum of data decoded from the stream
+	streamCRCPending bool   // data has been decoded since the last stream checksum
+
+	hdrPending   bool   // hdr holds a data chunk header not yet processed
+	stopAtMarker bool   // nextChunk stops at flush markers (see NextMessage)
+	headerDone   bool   // a data chunk has been read; no Header may follow
+	header       Header // Header read from the stream
+	headerErr    error  // error parsing header
+	sizeHint     int64  // decoded size hint read from the stream
+	hasSizeHint  bool   // sizeHint was read from the stream
 
 	buf bytes.Buffer
+	msg []byte // message returned by NextMessage
 	hdr []byte
 	src []byte
 	dst []byte
+
+	// opts is retained by Reset.  all configuration belongs in opts.
+	opts readerOptions
+}
+
+// readerOptions holds the configuration of a Reader.
+type readerOptions struct {
+	allowMissingStreamID bool
+	allowLeadingSkipped  bool // skippable chunks may precede the identifier
+	allowTruncation      bool
+	singleStream         bool
+	checksum             func([]byte) uint32
+	maxDecoded           int64
+	maxExpansion         int
+	discardSink          io.Writer
+	discardMax           int64
+	verifyStreamChecksum bool
+	onUnknown            func(typ byte, data []byte) error
+	acceptUnmasked       bool
+	skipLimit            int
+	skipPace             func(n int) error
+	expectLen            bool
+	expectedLen          int64
+	tracer               func(FrameEvent)
+	noBuffer             bool
+	hash                 hash.Hash
+	maxNonData           int
+	noWriteTo            bool
+	rejectEmpty          bool
 }
 
+// defaultReaderBufferSize is the initial size of a Reader's internal src and
+// dst buffers when created with NewReader.
+const defaultReaderBufferSize = 4096
+
+// minReaderBufferSize is the smallest initial buffer size accepted by
+// NewReaderSize.  It must be large enough to hold the stream identifier's
+// block data.
+const minReaderBufferSize = 16
+
 // NewReader returns an new Reader. Reads from the Reader retreive data
 // decompressed from a snappy framed stream read from sz.
 func NewReader(r io.Reader) *Reader {
+	return NewReaderSize(r, defaultReaderBufferSize)
+}
+
+// ReaderOptions configures a Reader created with NewReaderOptions.  The zero
+// value is the configuration used by NewReader.
+type ReaderOptions struct {
+	// Checksum, if not nil, replaces the masked CRC-32C checksum expected in
+	// each data chunk.  It must match the function used to write the stream
+	// (see WriterOptions).
+	Checksum func([]byte) uint32
+
+	// ChecksumKind selects the algorithm computing the checksum expected in
+	// each data chunk when Checksum is nil.  It must match the kind used to
+	// write the stream.  NewReaderOptions panics if ChecksumKind is not a
+	// known kind.
+	ChecksumKind ChecksumKind
+}
+
+// NewReaderOptions is like NewReader but configures the returned Reader with
+// opts.  If opts is nil the returned Reader is equivalent to one returned by
+// NewReader.  The configuration is retained when the Reader is Reset.
+func NewReaderOptions(r io.Reader, opts *ReaderOptions) *Reader {
+	sz := NewReader(r)
+	if opts != nil {
+		sz.opts.checksum = opts.Checksum
+		if opts.Checksum == nil {
+			sz.opts.checksum = opts.ChecksumKind.checksum()
+		}
+	}
+	return sz
+}
+
+// NewReaderSize is like NewReader but preallocates internal buffers to hold
+// blocks of bufSize bytes.  Buffers for encoded data are never allocated
+// larger than maxEncodedBlockSize+4 bytes and buffers for decoded data are
+// never allocated larger than maxBlockSize.  When bufSize is at least
+// maxEncodedBlockSize+4 the returned Reader will not reallocate its buffers to
+// decode any valid block.
+func NewReaderSize(r io.Reader, bufSize int) *Reader {
+	if bufSize < minReaderBufferSize {
+		bufSize = minReaderBufferSize
+	}
+	srcSize := bufSize
+	if srcSize > int(maxEncodedBlockSize+4) {
+		srcSize = int(maxEncodedBlockSize + 4)
+	}
+	dstSize := bufSize
+	if dstSize > maxBlockSize {
+		dstSize = maxBlockSize
+	}
 	return &Reader{
 		reader: r,
 
@@ -41,15 +239,22 @@ func NewReader(r io.Reader) *Reader {
 		// automatically and re-used and will never exceed the largest block size, 65536). The
 		// last buffer contains the *unread* decompressed bytes (and can grow indefinitely).
 		hdr: make([]byte, 4),
-		src: make([]byte, 4096),
-		dst: make([]byte, 4096),
+		src: make([]byte, srcSize),
+		dst: make([]byte, dstSize),
 	}
 }
 
 // WriteTo implements the io.WriterTo interface used by io.Copy.  It writes
 // decoded data from the underlying reader to w.  WriteTo returns the number of
 // bytes written along with any error encountered.
+//
+// io.Copy and similar functions call WriteTo automatically when sz is the
+// source.  After DisableWriteTo, WriteTo copies sz to w with io.Copy as if sz
+// did not implement io.WriterTo.
 func (sz *Reader) WriteTo(w io.Writer) (int64, error) {
+	if sz.opts.noWriteTo {
+		return io.Copy(w, struct{ io.Reader }{sz})
+	}
 	if sz.err != nil {
 		return 0, sz.err
 	}
@@ -67,18 +272,125 @@ func (sz *Reader) WriteTo(w io.Writer) (int64, error) {
 		w:   w,
 		buf: &sz.buf,
 	}
+	// wfallback.n counts every decoded byte written to w, including those
+	// from frames written before any error.
 	for {
-		var m int
-		m, err = sz.nextFrame(wfallback)
+		_, err = sz.nextFrame(wfallback)
 		if wfallback.writerErr != nil && err == nil {
-			// a partial write was made before an error occurred and not all m
+			// a partial write was made before an error occurred and not all
 			// bytes were writen to w.  but decoded bytes were successfully
 			// buffered and reading can resume later.
-			n += wfallback.n
-			return n, wfallback.writerErr
+			return n + wfallback.n, wfallback.writerErr
+		}
+		if err == io.EOF {
+			return n + wfallback.n, nil
 		}
+		if err != nil {
+			sz.err = err
+			return n + wfallback.n, err
+		}
+	}
+	panic("unreachable")
+}
+
+// WriteToLimit is like WriteTo but writes at most n decoded bytes to w.  The
+// block containing the nth byte is decoded in full and its remaining data is
+// buffered, so that sz may continue to be read from where WriteToLimit
+// stopped.  No further chunks are read from the underlying reader.  If the
+// stream ends before n bytes are written WriteToLimit returns the number of
+// bytes written and a nil error, as WriteTo does.
+func (sz *Reader) WriteToLimit(w io.Writer, n int64) (int64, error) {
+	if sz.err != nil {
+		return 0, sz.err
+	}
+	if n <= 0 {
+		return 0, nil
+	}
+
+	var written int64
+	if sz.buf.Len() > 0 {
+		k := min64(n, int64(sz.buf.Len()))
+		m, err := w.Write(sz.buf.Bytes()[:k])
+		sz.buf.Next(m)
+		written = int64(m)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	// data beyond the limit is buffered after any data buffered by
+	// wfallback following an error writing to w.
+	wfallback := &bufferFallbackWriter{
+		w:   w,
+		buf: &sz.buf,
+	}
+	wlimit := &prefixWriter{
+		w:   wfallback,
+		n:   n - written,
+		buf: &sz.buf,
+	}
+	for wlimit.n > 0 {
+		_, err := sz.nextFrame(wlimit)
+		if wfallback.writerErr != nil && err == nil {
+			return written + wfallback.n, wfallback.writerErr
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			sz.err = err
+			return written + wfallback.n, err
+		}
+	}
+	return written + wfallback.n, nil
+}
+
+// prefixWriter writes at most n bytes to w and buffers the remainder in buf.
+type prefixWriter struct {
+	w   io.Writer
+	n   int64
+	buf *bytes.Buffer
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	k := min64(w.n, int64(len(p)))
+	w.n -= k
+	_, err := w.w.Write(p[:k])
+	if err != nil {
+		return 0, err
+	}
+	w.buf.Write(p[k:])
+	return len(p), nil
+}
+
+// DisableWriteTo causes WriteTo to copy decoded data through the generic copy
+// path used by io.Copy, reading it from sz with Read, instead of writing
+// decoded blocks directly.  It is intended for troubleshooting suspected
+// problems in WriteTo.  The setting is retained when sz is Reset.
+func (sz *Reader) DisableWriteTo() {
+	sz.opts.noWriteTo = true
+}
+
+// Discard decodes and discards the remainder of the stream, returning the
+// number of decoded bytes discarded, including data buffered by previous
+// calls to Read.  Blocks are verified as they are by Read, and Discard returns
+// any error encountered other than io.EOF.  After Discard returns, further
+// calls to Read return io.EOF or the error returned by Discard.
+func (sz *Reader) Discard() (int64, error) {
+	if sz.err == io.EOF {
+		return 0, nil
+	}
+	if sz.err != nil {
+		return 0, sz.err
+	}
+
+	n := int64(sz.buf.Len())
+	sz.buf.Reset()
+	for {
+		m, err := sz.nextFrame(ioutil.Discard)
 		n += int64(m)
 		if err == io.EOF {
+			sz.err = io.EOF
 			return n, nil
 		}
 		if err != nil {
@@ -86,7 +398,67 @@ func (sz *Reader) WriteTo(w io.Writer) (int64, error) {
 			return n, err
 		}
 	}
-	panic("unreachable")
+}
+
+// Seek implements a forward-only io.Seeker over the decoded data.  Offsets are
+// relative to the beginning of the decoded stream (io.SeekStart) or the
+// current position (io.SeekCurrent).  Seeking forward decodes and discards
+// data, verifying blocks as Read does.  Seeking backward or relative to the
+// end of the stream (io.SeekEnd) returns an error.  If the stream ends before
+// the target offset Seek returns the final offset and io.EOF.  Seek returns
+// the new offset relative to the beginning of the stream.
+func (sz *Reader) Seek(offset int64, whence int) (int64, error) {
+	pos := sz.decoded - int64(sz.buf.Len())
+	switch whence {
+	case io.SeekStart:
+		offset -= pos
+	case io.SeekCurrent:
+	default:
+		return pos, fmt.Errorf("seek: unsupported whence %d", whence)
+	}
+	if offset < 0 {
+		return pos, fmt.Errorf("seek: backward seek")
+	}
+	if offset == 0 {
+		return pos, nil
+	}
+	if sz.err != nil {
+		return pos, sz.err
+	}
+
+	// skip buffered data before decoding more.
+	buffered := min64(offset, int64(sz.buf.Len()))
+	sz.buf.Next(int(buffered))
+	w := &skipWriter{n: offset - buffered, buf: &sz.buf}
+	for w.n > 0 {
+		_, err := sz.nextFrame(w)
+		if err != nil {
+			sz.err = err
+			return sz.decoded - int64(sz.buf.Len()), err
+		}
+	}
+	return sz.decoded - int64(sz.buf.Len()), nil
+}
+
+// skipWriter discards the first n bytes written to it and writes the
+// remainder to buf.
+type skipWriter struct {
+	n   int64
+	buf *bytes.Buffer
+}
+
+func (w *skipWriter) Write(p []byte) (int, error) {
+	k := min64(w.n, int64(len(p)))
+	w.n -= k
+	w.buf.Write(p[k:])
+	return len(p), nil
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
 }
 
 // bufferFallbackWriter writes to an underlying io.Writer until an error
@@ -125,21 +497,329 @@ func (w *bufferFallbackWriter) Write(b []byte) (int, error) {
 	return n, nil
 }
 
-// Reset discards internal state and sets the underlying reader to r.  Reset
-// does not alter the reader's verification of checksums.  After Reset returns
-// the reader is equivalent to one returned by NewReader.  Reusing readers with
-// Reset can significantly reduce allocation overhead in applications making
-// heavy use of snappy framed format streams.
-func (sz *Reader) Reset(r io.Reader) {
+// Reset discards internal state and sets the underlying reader to r.  After
+// Reset returns the reader is equivalent to one returned by NewReader that has
+// been given the same configuration as sz.  Reusing readers with Reset can
+// significantly reduce allocation overhead in applications making heavy use of
+// snappy framed format streams.
+//
+// Reset clears all state pertaining to the stream being read: any error,
+// unread decoded data, whether a stream identifier has been read, and the
+// count of decoded bytes.  Reset retains all configuration set by methods of
+// sz, such as AllowMissingStreamID and SetMaxDecodedBytes.  If the previous
+// stream was ended by a stream identifier (see Multistream) and r is the
+// reader from which the identifier was read, the new stream begins with that
+// identifier.
+//
+// Reset currently always returns nil.  Its signature matches
+// gzip.Reader.Reset so that a Reader satisfies interface{ Reset(io.Reader)
+// error }, which frameworks reusing decoders from the compress packages
+// commonly require.
+func (sz *Reader) Reset(r io.Reader) error {
+	// the identifier which ended the previous stream begins the next one.
+	continued := sz.streamEnd && sameReader(sz.nextStream, r)
+
 	sz.err = nil
 	sz.reader = r
+	sz.seenStreamID = continued
+	sz.streamEnd = false
+	sz.nextStream = nil
+	sz.decoded = 0
+	sz.chunkOffset = 0
+	sz.nextOffset = 0
+	sz.discarded = 0
+	sz.nonData = 0
+	sz.streamCRC = 0
+	sz.streamCRCPending = false
+	sz.hdrPending = false
+	sz.headerDone = false
+	sz.header = Header{}
+	sz.headerErr = nil
+	sz.sizeHint = 0
+	sz.hasSizeHint = false
 	sz.buf.Truncate(0)
+	if continued {
+		sz.nextOffset = int64(len(streamID))
+		sz.streamCRCPending = sz.opts.verifyStreamChecksum
+	}
+	if sz.src == nil {
+		// buffers were released by Close
+		sz.src = make([]byte, defaultReaderBufferSize)
+		sz.dst = make([]byte, defaultReaderBufferSize)
+	}
+	return nil
 }
 
-func (sz *Reader) read(b []byte) (int, error) {
-	n, err := sz.buf.Read(b)
-	sz.err = err
-	return n, err
+// sameReader returns true if a and b are the same non-nil io.Reader.  Readers
+// of incomparable types are never the same.
+func sameReader(a, b io.Reader) bool {
+	if a == nil || b == nil || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// AllowMissingStreamID controls whether sz accepts streams which do not begin
+// with a stream identifier.  Such streams are not conformant and are rejected
+// by default.  Chunks are otherwise decoded and verified normally.  The
+// setting is retained when sz is Reset.
+func (sz *Reader) AllowMissingStreamID(allow bool) {
+	sz.opts.allowMissingStreamID = allow
+}
+
+// Leniency is a level of tolerance for nonconformant streams, set with
+// Reader.SetLeniency.  Each level tolerates everything tolerated by the
+// levels below it.
+type Leniency int
+
+// Leniency levels accepted by Reader.SetLeniency.
+const (
+	// LeniencyNone accepts only conformant streams.  It is the default.
+	LeniencyNone Leniency = iota
+
+	// LeniencyLeadingSkippable discards padding and reserved skippable chunks
+	// preceding the stream identifier (see RequireLeadingStreamID).
+	LeniencyLeadingSkippable
+
+	// LeniencyMissingStreamID accepts streams without a stream identifier
+	// (see AllowMissingStreamID).
+	LeniencyMissingStreamID
+)
+
+// SetLeniency sets the tolerance of sz for nonconformant streams produced by
+// real encoders, overriding the settings of RequireLeadingStreamID and
+// AllowMissingStreamID.  Chunks are otherwise decoded and verified normally.
+// The setting is retained when sz is Reset.
+func (sz *Reader) SetLeniency(level Leniency) {
+	sz.opts.allowLeadingSkipped = level >= LeniencyLeadingSkippable
+	sz.opts.allowMissingStreamID = level >= LeniencyMissingStreamID
+}
+
+// RejectEmptyInput controls whether sz accepts input which ends before a
+// stream identifier or data block is read, such as empty input.  When
+// enabled, such input causes ErrEmptyInput instead of io.EOF, while a stream
+// identifier followed by EOF still results in io.EOF.  Empty input is accepted
+// by default.  The setting is retained when sz is Reset.
+func (sz *Reader) RejectEmptyInput(reject bool) {
+	sz.opts.rejectEmpty = reject
+}
+
+// RequireLeadingStreamID controls whether the stream identifier must be the
+// first chunk of a stream, as required by the framing format.  When disabled,
+// padding and reserved skippable chunks preceding the identifier are
+// discarded, while data and unskippable chunks preceding it remain an error
+// unless AllowMissingStreamID is enabled.  The identifier is required to be
+// first by default.  The setting is retained when sz is Reset.
+func (sz *Reader) RequireLeadingStreamID(require bool) {
+	sz.opts.allowLeadingSkipped = !require
+}
+
+// AllowTruncation controls whether sz treats a stream truncated by EOF inside a
+// chunk as ending cleanly.  When enabled, Read and WriteTo return all data from
+// complete, verified blocks followed by io.EOF and the partial chunk is
+// discarded.  If stream checksums are verified, a truncated stream still
+// lacks its checksum and results in an error.  Truncation results in
+// io.ErrUnexpectedEOF by default.  The setting is retained when sz is Reset.
+func (sz *Reader) AllowTruncation(allow bool) {
+	sz.opts.allowTruncation = allow
+}
+
+// Multistream controls whether sz reads concatenated streams as a single
+// stream, similar to gzip.Reader.Multistream.  A stream identifier following
+// the first is ignored when multistream mode is enabled, which is the default.
+// When disabled, sz treats such an identifier as the end of the stream and
+// Read returns io.EOF.  The identifier is consumed, leaving the underlying
+// reader positioned at the first chunk of the following stream's data.  If sz
+// is then Reset with the same underlying reader the consumed identifier begins
+// the following stream, so each stream in a concatenation may be read in turn
+// as with gzip.  The setting is retained when sz is Reset.
+func (sz *Reader) Multistream(ok bool) {
+	sz.opts.singleStream = !ok
+}
+
+// VerifyStreamChecksum controls whether sz verifies stream checksums written by
+// a Writer with the StreamChecksum option.  When enabled, every stream must end
+// with a checksum matching all data decoded from it or Read and WriteTo return
+// an error in place of io.EOF.  A mismatched checksum results in
+// ErrStreamChecksum.  Stream checksums are ignored by default.  The setting is
+// retained when sz is Reset.
+func (sz *Reader) VerifyStreamChecksum(verify bool) {
+	sz.opts.verifyStreamChecksum = verify
+}
+
+// SetScratch provides caller-owned buffers for sz to use when reading encoded
+// blocks (src) and decoding them (dst), replacing the internal buffers of sz.
+// Blocks which fit within the capacity of the provided buffers are decoded
+// without allocation.  If a block exceeds the capacity of a buffer sz
+// allocates a larger one, which is not visible to the caller.  The caller must
+// not modify the buffers while sz is in use.  A src buffer with capacity less
+// than 16 bytes is ignored.
+func (sz *Reader) SetScratch(src, dst []byte) {
+	if cap(src) >= minReaderBufferSize {
+		sz.src = src[:cap(src)]
+	}
+	sz.dst = dst[:cap(dst)]
+}
+
+// Underlying returns the io.Reader from which sz reads the encoded stream, or
+// nil if sz has been closed.  sz reads exactly the chunks it decodes and never
+// reads ahead, so the underlying reader is positioned immediately after the
+// last chunk read by sz.  Any data following that chunk, such as the chunks of
+// a following stream (see Multistream) or unrelated data after the stream, may
+// be read from the underlying reader directly.  Chunks which follow the final
+// data block read by sz, such as padding or a stream checksum, are not
+// consumed until sz reads past them.
+func (sz *Reader) Underlying() io.Reader {
+	return sz.reader
+}
+
+// StreamIDSeen returns true if sz has read a stream identifier from the
+// current stream.  It returns false until the identifier is read, and after sz
+// is Reset.
+func (sz *Reader) StreamIDSeen() bool {
+	return sz.seenStreamID
+}
+
+// Close releases the internal buffers of sz and any unread decoded data.
+// After Close returns all methods of sz return ErrReaderClosed until sz is
+// Reset.  Close does not close the underlying io.Reader.
+func (sz *Reader) Close() error {
+	if sz.err == ErrReaderClosed {
+		return sz.err
+	}
+	sz.err = ErrReaderClosed
+	sz.reader = nil
+	sz.buf = bytes.Buffer{}
+	sz.src = nil
+	sz.dst = nil
+	return nil
+}
+
+// SetMaxDecodedBytes limits the total number of bytes sz will decode from a
+// stream to n.  Once decoding a block would cause the total to exceed n, Read
+// and WriteTo return ErrDecodedLimitExceeded and the block's data is not
+// made available.  Data from preceding blocks is still returned.  If n is not
+// positive the number of decoded bytes is unlimited, which is the default.
+// The limit is retained when sz is Reset.
+//
+// SetMaxDecodedBytes provides protection against decompression bombs when
+// reading untrusted input.
+func (sz *Reader) SetMaxDecodedBytes(n int64) {
+	sz.opts.maxDecoded = n
+}
+
+// ExpectDecodedLen causes sz to verify that the stream decodes to exactly n
+// bytes.  If the stream ends before n bytes have been decoded, Read and
+// WriteTo return ErrLengthMismatch instead of io.EOF.  A block which would
+// cause more than n bytes to be decoded results in ErrLengthMismatch and its
+// data is not made available.  Unlike block checksums the expected length
+// detects streams truncated on a block boundary.  If n is negative the length
+// is not checked, which is the default.  The length is retained when sz is
+// Reset.
+func (sz *Reader) ExpectDecodedLen(n int64) {
+	sz.opts.expectLen = n >= 0
+	sz.opts.expectedLen = n
+}
+
+// SetDiscardSink causes the data of chunks discarded by sz, such as padding
+// and reserved chunks, to be written to w.  The reserved chunks include those
+// holding a Header or decoded size hint, which sz parses as well.  At most
+// maxBytes bytes from each
+// stream are written to w, after which discarded data is dropped.  An error
+// writing to w interrupts decoding.  If w is nil discarded data is dropped,
+// which is the default.  The sink is retained when sz is Reset.
+func (sz *Reader) SetDiscardSink(w io.Writer, maxBytes int64) {
+	sz.opts.discardSink = w
+	sz.opts.discardMax = maxBytes
+}
+
+// SetSkipLimit bounds the amount of data sz reads from the underlying reader
+// at once while skipping the data of padding and other chunks which are not
+// decoded, including chunks holding a Header or decoded size hint.  A chunk
+// may contain up to 16MiB of data, which sz skips in segments of at most n bytes.  If pace is not nil
+// it is called with the size of each segment before the segment is read,
+// allowing a rate limiter to delay reading, and an error returned by pace is
+// returned by sz.  If n is less than or equal to zero segments are at most
+// MaxBlockSize bytes, which is the default.  The settings are retained when sz is Reset.
+func (sz *Reader) SetSkipLimit(n int, pace func(n int) error) {
+	sz.opts.skipLimit = n
+	sz.opts.skipPace = pace
+}
+
+// SetMaxNonDataChunks limits the number of consecutive chunks without data,
+// such as padding, stream identifiers, and reserved skippable chunks, that sz
+// reads between data blocks.  Reading a chunk beyond the limit causes
+// ErrNonDataChunkLimit, guarding against streams which are (nearly) all
+// padding.  The chunk's header has been read but its data has not.  If n is
+// less than or equal to zero the number of chunks is not limited, which is the
+// default.  The limit is retained when sz is Reset.
+func (sz *Reader) SetMaxNonDataChunks(n int) {
+	sz.opts.maxNonData = n
+}
+
+// SetMaxBlockExpansion limits the ratio of the decoded size of each compressed
+// block to its compressed size, guarding against blocks crafted to expand
+// dramatically.  A compressed block which would decode to more than ratio
+// times its compressed size causes ErrBlockExpansion before the block is
+// decoded.  A ratio less than or equal to zero removes the limit, which is the
+// default.  The limit is retained when sz is Reset.
+func (sz *Reader) SetMaxBlockExpansion(ratio int) {
+	sz.opts.maxExpansion = ratio
+}
+
+// exceedsExpansion returns true if declen is greater than ratio times enclen.
+// The comparison is made by division so that it cannot overflow.
+func exceedsExpansion(declen, enclen, ratio int) bool {
+	if enclen <= 0 {
+		return declen > 0
+	}
+	q := declen / enclen
+	return q > ratio || q == ratio && declen%enclen > 0
+}
+
+// OnUnknownUnskippable sets a function called with the type and data of each
+// chunk of a reserved unskippable type (0x02-0x7f) read by sz.  If fn returns
+// nil the chunk is skipped, and otherwise the error is returned by sz.  The
+// data slice is only valid until fn returns.  Chunks longer than the largest
+// data chunk are errors and are not passed to fn.
+//
+// Skipping unskippable chunks is not conformant: a chunk which alters the
+// meaning of the data following it will be silently misinterpreted.  When fn
+// is nil, the default, unskippable chunks cause an error.  The function is
+// retained when sz is Reset.
+func (sz *Reader) OnUnknownUnskippable(fn func(typ byte, data []byte) error) {
+	sz.opts.onUnknown = fn
+}
+
+// AcceptUnmaskedChecksums controls whether sz accepts data blocks whose
+// checksum is the unmasked CRC-32C checksum of their data, as written by some
+// nonconformant producers.  When enabled a block whose checksum does not match
+// the masked checksum required by the specification is accepted if it matches
+// the unmasked checksum instead.  The setting has no effect on a Reader
+// created with a custom Checksum.  Unmasked checksums are rejected by default.
+// The setting is retained when sz is Reset.
+func (sz *Reader) AcceptUnmaskedChecksums(ok bool) {
+	sz.opts.acceptUnmasked = ok
+}
+
+// SetNoBuffer controls whether Read decodes data blocks directly into the
+// buffer passed to it, bypassing the internal buffer.  When enabled each call
+// to Read returns the data of exactly one block, and Read returns
+// io.ErrShortBuffer without reading if len(b) is less than MaxBlockSize.
+// Data already buffered, such as by ReadByte, is returned by Read before
+// unbuffered reading begins.  Unbuffered reading is disabled by default.  The
+// setting is retained when sz is Reset.
+func (sz *Reader) SetNoBuffer(ok bool) {
+	sz.opts.noBuffer = ok
+}
+
+// SetPlaintextHash causes all data decoded by sz to also be written to h, so
+// that a digest of the decoded stream is computed without a second pass.  Data
+// is written to h as each block is decoded and verified, before it is returned
+// by Read or written by WriteTo.  If h is nil, the default, no hash is
+// computed.  The hash is retained when sz is Reset but h itself is not reset.
+func (sz *Reader) SetPlaintextHash(h hash.Hash) {
+	sz.opts.hash = h
 }
 
 // Read fills b with any decoded data remaining in the Reader's internal
@@ -147,71 +827,357 @@ func (sz *Reader) read(b []byte) (int, error) {
 // from the underlying to fill b with.
 //
 // Read returns an error if the first chunk encountered in the underlying
-// reader is not a snappy-framed stream identifier.
+// reader is not a snappy-framed stream identifier.  Data decoded before an
+// error, including io.EOF, is returned before the error.  Once the error has
+// been returned, every later call to Read returns it without reading from the
+// underlying reader.
 func (sz *Reader) Read(b []byte) (int, error) {
 	if sz.err != nil {
 		return 0, sz.err
 	}
+	if sz.opts.noBuffer && sz.buf.Len() == 0 {
+		return sz.readUnbuffered(b)
+	}
 
 	if sz.buf.Len() < len(b) {
-		_, sz.err = sz.nextFrame(&sz.buf)
-		if sz.err == io.EOF {
-			// fill b with any remaining bytes in the buffer.
-			return sz.read(b)
+		_, err := sz.nextFrame(&sz.buf)
+		for err == nil && sz.buf.Len() == 0 {
+			// a data block may legally contain no data.
+			_, err = sz.nextFrame(&sz.buf)
 		}
+		if err != nil {
+			// return data decoded from preceding blocks before the error.
+			// the buffer fits entirely in b so the error will be returned by
+			// the next call to Read.
+			sz.err = err
+			n, _ := sz.buf.Read(b)
+			if n == 0 {
+				return 0, err
+			}
+			return n, nil
+		}
+	}
+
+	// the buffer is not empty unless b is.
+	return sz.buf.Read(b)
+}
+
+// ReadByte implements the io.ByteReader interface.  ReadByte returns the next
+// decoded byte from the Reader's internal buffer, decoding a chunk from the
+// underlying reader when the buffer is empty.  ReadByte returns io.EOF after
+// all data has been decoded.
+func (sz *Reader) ReadByte() (byte, error) {
+	if sz.err != nil {
+		return 0, sz.err
+	}
+
+	// a data block may legally contain no data so decode until a byte is
+	// available.
+	for sz.buf.Len() == 0 {
+		_, sz.err = sz.nextFrame(&sz.buf)
 		if sz.err != nil {
 			return 0, sz.err
 		}
 	}
 
-	return sz.read(b)
+	return sz.buf.ReadByte()
+}
+
+// readUnbuffered decodes the next data block directly into b.
+func (sz *Reader) readUnbuffered(b []byte) (int, error) {
+	if len(b) < maxBlockSize {
+		return 0, io.ErrShortBuffer
+	}
+	w := &fillWriter{b: b, buf: &sz.buf}
+	for w.n == 0 {
+		// a data block may legally contain no data.
+		_, err := sz.nextFrame(w)
+		if err != nil {
+			sz.err = err
+			return 0, err
+		}
+	}
+	return w.n, nil
+}
+
+// ReadFull reads exactly len(b) bytes from sz into b, decoding as many blocks
+// as necessary.  Blocks are decoded directly into b when it has room for
+// their data, avoiding the copy through the internal buffer made by Read.
+// ReadFull follows the conventions of io.ReadFull: it returns io.EOF only if
+// no bytes were read, and io.ErrUnexpectedEOF if the stream ends after some
+// but not all of b has been filled.  Unlike Read, ReadFull blocks until b is
+// full, so it is not suitable for interactive streams.
+func (sz *Reader) ReadFull(b []byte) (int, error) {
+	if sz.err != nil {
+		return 0, sz.err
+	}
+
+	n, _ := sz.buf.Read(b)
+	w := &fillWriter{b: b, n: n, buf: &sz.buf}
+	for w.n < len(b) {
+		_, err := sz.nextFrame(w)
+		if err != nil {
+			sz.err = err
+			if err == io.EOF && w.n > 0 {
+				return w.n, io.ErrUnexpectedEOF
+			}
+			return w.n, err
+		}
+	}
+	return w.n, nil
+}
+
+// fillWriter is an io.Writer that copies data into b and buffers data which
+// does not fit in buf.
+type fillWriter struct {
+	b   []byte
+	n   int
+	buf *bytes.Buffer
+}
+
+func (w *fillWriter) Write(p []byte) (int, error) {
+	m := copy(w.b[w.n:], p)
+	w.n += m
+	if m < len(p) {
+		w.buf.Write(p[m:])
+	}
+	return len(p), nil
+}
+
+// ReadFrame returns the data decoded from the next data block in the stream,
+// exposing the block boundaries hidden by Read.  The returned slice is only
+// valid until the next call to a method of sz.  Blocks are verified as they
+// are by Read, and a block containing no data results in an empty slice.  If
+// data remains buffered from a previous call to Read or ReadByte it is
+// returned before the next block is decoded.  ReadFrame returns io.EOF after
+// all blocks have been read.
+func (sz *Reader) ReadFrame() ([]byte, error) {
+	if sz.err != nil {
+		return nil, sz.err
+	}
+	if sz.buf.Len() > 0 {
+		return sz.buf.Next(sz.buf.Len()), nil
+	}
+
+	var w frameWriter
+	_, sz.err = sz.nextFrame(&w)
+	if sz.err != nil {
+		return nil, sz.err
+	}
+	return w.p, nil
+}
+
+// DecodeInto decodes the next data block in the stream into dst and returns
+// the number of bytes decoded and the slice of dst holding them.  If dst does
+// not have the capacity to hold the block's data a larger slice is allocated
+// and returned, which the caller may pass to later calls to avoid further
+// allocation.  A dst with capacity MaxBlockSize can hold any block.  Unlike
+// ReadFrame, the returned slice belongs to the caller and remains valid after
+// further calls to methods of sz.  If data remains buffered from a previous
+// call to Read or ReadByte it is returned before the next block is decoded.
+// Blocks are verified as they are by Read, and DecodeInto returns io.EOF after
+// all blocks have been read.
+func (sz *Reader) DecodeInto(dst []byte) (int, []byte, error) {
+	if sz.err != nil {
+		return 0, dst[:0], sz.err
+	}
+	if sz.buf.Len() > 0 {
+		block := append(dst[:0], sz.buf.Next(sz.buf.Len())...)
+		return len(block), block, nil
+	}
+
+	w := &appendWriter{p: dst[:0]}
+	_, sz.err = sz.nextFrame(w)
+	if sz.err != nil {
+		return 0, dst[:0], sz.err
+	}
+	return len(w.p), w.p, nil
+}
+
+// appendWriter is an io.Writer that appends data to p.
+type appendWriter struct {
+	p []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.p = append(w.p, p...)
+	return len(p), nil
 }
 
+// frameWriter is an io.Writer that retains the slice passed to Write, allowing
+// ReadFrame to return decoded data without copying it.
+type frameWriter struct {
+	p []byte
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	w.p = p
+	return len(p), nil
+}
+
+// nextFrame decodes the next data block in the stream and writes its data to
+// w.  When the stream ends its length is checked against the length set with
+// ExpectDecodedLen.
 func (sz *Reader) nextFrame(w io.Writer) (int, error) {
+	n, err := sz.decodeFrame(w)
+	if err == io.EOF && sz.opts.expectLen && sz.decoded != sz.opts.expectedLen {
+		return n, ErrLengthMismatch
+	}
+	return n, err
+}
+
+func (sz *Reader) decodeFrame(w io.Writer) (int, error) {
 	for {
-		// read the 4-byte snappy frame header
-		_, err := io.ReadFull(sz.reader, sz.hdr)
+		err := sz.nextChunk()
+		if err != nil {
+			return 0, sz.truncated(err)
+		}
+		sz.hdrPending = false
+		sz.headerDone = true
+
+		typ := sz.hdr[0]
+		if typ == blockCompressed || typ == blockUncompressed {
+			n, err := sz.decodeBlock(w)
+			if err != nil {
+				return n, sz.truncated(err)
+			}
+			sz.trace(n)
+			return n, nil
+		}
+
+		// typ must be unskippable range 0x02-0x7f.  Read the block in full
+		// and return an error (4.5 Reserved unskippable chunks) unless the
+		// callback set with OnUnknownUnskippable allows it to be skipped.
+		if sz.opts.onUnknown == nil {
+			err = sz.discardBlock()
+			if err != nil {
+				return 0, sz.truncated(err)
+			}
+			return 0, fmt.Errorf("unrecognized unskippable frame %#x", typ)
+		}
+		data, err := sz.readBlock()
+		if err != nil {
+			return 0, sz.truncated(err)
+		}
+		err = sz.opts.onUnknown(typ, data)
 		if err != nil {
 			return 0, err
 		}
+		sz.trace(0)
+	}
+}
+
+// truncated returns the error reported for err, which ended the current
+// frame.  When truncation is allowed io.ErrUnexpectedEOF ends the stream.
+func (sz *Reader) truncated(err error) error {
+	if err != io.ErrUnexpectedEOF || !sz.opts.allowTruncation {
+		return err
+	}
+	if sz.streamCRCPending {
+		return errMissingStreamChecksum
+	}
+	return io.EOF
+}
+
+// nextChunk reads chunks from the underlying reader until the header of a
+// data block or unskippable chunk has been read into sz.hdr.  Stream
+// identifiers and skippable chunks are processed as they are encountered.
+// Until nextFrame consumes the chunk, further calls to nextChunk return
+// immediately.
+func (sz *Reader) nextChunk() error {
+	if sz.reader == nil {
+		return ErrNilTarget
+	}
+	if sz.hdrPending {
+		return nil
+	}
+	if sz.streamEnd {
+		return io.EOF
+	}
+	for {
+		err := sz.readHeader()
+		if err == io.EOF && sz.streamCRCPending {
+			return errMissingStreamChecksum
+		}
+		if err == io.EOF && sz.opts.rejectEmpty && !sz.seenStreamID && !sz.headerDone {
+			return ErrEmptyInput
+		}
+		if err != nil {
+			return err
+		}
+		if typ := sz.hdr[0]; typ == blockCompressed || typ == blockUncompressed {
+			sz.nonData = 0
+		} else {
+			sz.nonData++
+			if sz.opts.maxNonData > 0 && sz.nonData > sz.opts.maxNonData {
+				return ErrNonDataChunkLimit
+			}
+		}
 
 		// a stream identifier may appear anywhere and contains no information.
 		// it must appear at the beginning of the stream.  when found, validate
-		// it and continue to the next block.
+		// it and continue to the next block unless it ends a single stream.
 		if sz.hdr[0] == blockStreamIdentifier {
 			err := sz.readStreamID()
 			if err != nil {
-				return 0, err
+				return err
+			}
+			sz.trace(0)
+			// the identifier begins a new stream, which must follow the
+			// previous stream's checksum.
+			if sz.streamCRCPending {
+				return errMissingStreamChecksum
+			}
+			if sz.seenStreamID && sz.opts.singleStream {
+				sz.streamEnd = true
+				sz.nextStream = sz.reader
+				return io.EOF
 			}
 			sz.seenStreamID = true
+			if sz.opts.verifyStreamChecksum {
+				sz.streamCRC = 0
+				sz.streamCRCPending = true
+			}
 			continue
 		}
-		if !sz.seenStreamID {
-			return 0, errMissingStreamID
+		if !sz.seenStreamID && !sz.opts.allowMissingStreamID {
+			if !sz.opts.allowLeadingSkipped || !isSkippable(sz.hdr[0]) {
+				return errMissingStreamID
+			}
 		}
 
 		switch typ := sz.hdr[0]; {
-		case typ == blockCompressed || typ == blockUncompressed:
-			return sz.decodeBlock(w)
-		case typ == blockPadding || (0x80 <= typ && typ <= 0xfd):
+		case typ == blockStreamChecksum && sz.opts.verifyStreamChecksum:
+			err := sz.readStreamChecksum()
+			if err != nil {
+				return err
+			}
+		case typ == blockStreamHeader && !sz.headerDone:
+			err := sz.readStreamHeader()
+			if err != nil {
+				return err
+			}
+		case typ == blockSizeHint:
+			err := sz.readSizeHint()
+			if err != nil {
+				return err
+			}
+		case typ == blockPadding && sz.stopAtMarker && decodeLength(sz.hdr[1:]) == 0:
+			sz.trace(0)
+			return errMessageBoundary
+		case isSkippable(typ):
 			// skip blocks whose data must not be inspected (4.4 Padding, and 4.6
 			// Reserved skippable chunks).
 			err := sz.discardBlock()
 			if err != nil {
-				return 0, err
+				return err
 			}
-			continue
 		default:
-			// typ must be unskippable range 0x02-0x7f.  Read the block in full
-			// and return an error (4.5 Reserved unskippable chunks).
-			err = sz.discardBlock()
-			if err != nil {
-				return 0, err
-			}
-			return 0, fmt.Errorf("unrecognized unskippable frame %#x", sz.hdr[0])
+			sz.hdrPending = true
+			return nil
 		}
+		sz.trace(0)
 	}
-	panic("unreachable")
 }
 
 // decodeDataBlock assumes sz.hdr[0] to be either blockCompressed or
@@ -223,6 +1189,9 @@ func (sz *Reader) decodeBlock(w io.Writer) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	if len(buf) < minDataBlockSize {
+		return 0, ErrEmptyBlock
+	}
 	declen := len(buf[4:])
 	if sz.hdr[0] == blockCompressed {
 		declen, err = snappy.DecodedLen(buf[4:])
@@ -231,27 +1200,117 @@ func (sz *Reader) decodeBlock(w io.Writer) (int, error) {
 		}
 	}
 	if declen > maxBlockSize {
-		return 0, fmt.Errorf("decoded block data too large %d > %d", declen, maxBlockSize)
+		return 0, fmt.Errorf("%w: decoded length %d > %d", ErrBlockTooLarge, declen, maxBlockSize)
+	}
+	if sz.opts.maxExpansion > 0 && sz.hdr[0] == blockCompressed && exceedsExpansion(declen, len(buf[4:]), sz.opts.maxExpansion) {
+		return 0, ErrBlockExpansion
 	}
 
 	// decode data and verify its integrity using the little-endian crc32
 	// preceding encoded data
 	crc32le, blockdata := buf[:4], buf[4:]
 	if sz.hdr[0] == blockCompressed {
-		sz.dst, err = snappy.Decode(sz.dst, blockdata)
+		if declen > cap(sz.dst) {
+			sz.dst = make([]byte, maxBlockSize)
+		}
+		// reslice dst so snappy.Decode may use its full capacity.
+		dec, err := snappyDecode(sz.dst[:cap(sz.dst)], blockdata)
 		if err != nil {
 			return 0, err
 		}
+		// the guard above ensures dst is large enough.  a decoder which
+		// produces anything other than declen bytes must not replace dst, so
+		// that a misbehaving block cannot grow the buffer beyond maxBlockSize.
+		if len(dec) != declen {
+			return 0, ErrDecodedLength
+		}
+		sz.dst = dec
 		blockdata = sz.dst
 	}
-	checksum := unmaskChecksum(uint32(crc32le[0]) | uint32(crc32le[1])<<8 | uint32(crc32le[2])<<16 | uint32(crc32le[3])<<24)
-	actualChecksum := crc32.Checksum(blockdata, crcTable)
-	if checksum != actualChecksum {
-		return 0, fmt.Errorf("checksum does not match %x != %x", checksum, actualChecksum)
+	checksum := uint32(crc32le[0]) | uint32(crc32le[1])<<8 | uint32(crc32le[2])<<16 | uint32(crc32le[3])<<24
+	actualChecksum := sz.checksum(blockdata)
+	if checksum != actualChecksum && !sz.unmaskedChecksum(checksum, blockdata) {
+		return 0, &ChecksumError{
+			Offset:     sz.chunkOffset,
+			Length:     sz.nextOffset - sz.chunkOffset,
+			Compressed: sz.hdr[0] == blockCompressed,
+			DecodedLen: len(blockdata),
+			Checksum:   checksum,
+			Actual:     actualChecksum,
+		}
+	}
+	if sz.opts.maxDecoded > 0 && sz.decoded+int64(len(blockdata)) > sz.opts.maxDecoded {
+		return 0, ErrDecodedLimitExceeded
+	}
+	if sz.opts.expectLen && sz.decoded+int64(len(blockdata)) > sz.opts.expectedLen {
+		return 0, ErrLengthMismatch
+	}
+	sz.decoded += int64(len(blockdata))
+	if sz.opts.verifyStreamChecksum {
+		sz.streamCRC = crc32.Update(sz.streamCRC, crcTable, blockdata)
+		sz.streamCRCPending = true
+	}
+	if sz.opts.hash != nil {
+		sz.opts.hash.Write(blockdata)
 	}
 	return w.Write(blockdata)
 }
 
+// checksum returns the checksum expected in a data chunk with decoded data p.
+func (sz *Reader) checksum(p []byte) uint32 {
+	if sz.opts.checksum != nil {
+		return sz.opts.checksum(p)
+	}
+	return crc(p)
+}
+
+// unmaskedChecksum returns true if sz accepts unmasked checksums and checksum
+// is the unmasked CRC-32C checksum of p.
+func (sz *Reader) unmaskedChecksum(checksum uint32, p []byte) bool {
+	if !sz.opts.acceptUnmasked || sz.opts.checksum != nil {
+		return false
+	}
+	return checksum == crc32.Checksum(p, crcTable)
+}
+
+// snappyDecode decodes compressed block data.  It is a variable so that tests
+// may simulate a faulty decoder.
+var snappyDecode = snappy.Decode
+
+// readHeader reads the 4-byte chunk header into sz.hdr.  readHeader returns
+// io.EOF only if the underlying reader is at EOF on a chunk boundary.  A header
+// truncated by EOF results in io.ErrUnexpectedEOF.
+func (sz *Reader) readHeader() error {
+	// the previous chunk has been consumed in full.
+	sz.chunkOffset = sz.nextOffset
+	_, err := io.ReadFull(sz.reader, sz.hdr)
+	if err != nil {
+		return err
+	}
+	sz.nextOffset = sz.chunkOffset + int64(len(sz.hdr)) + int64(decodeLength(sz.hdr[1:]))
+	return nil
+}
+
+// readStreamChecksum reads a stream checksum chunk and verifies it against the
+// checksum of data decoded from the stream.
+func (sz *Reader) readStreamChecksum() error {
+	length := decodeLength(sz.hdr[1:])
+	if length != 4 {
+		return fmt.Errorf("invalid stream checksum length %d", length)
+	}
+	buf := sz.src[:4]
+	_, err := noeof(io.ReadFull(sz.reader, buf))
+	if err != nil {
+		return err
+	}
+	checksum := unmaskChecksum(uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24)
+	if checksum != sz.streamCRC {
+		return ErrStreamChecksum
+	}
+	sz.streamCRCPending = false
+	return nil
+}
+
 func (sz *Reader) readStreamID() error {
 	// the length of the block is fixed so don't decode it from the header.
 	if !bytes.Equal(sz.hdr, streamID[:4]) {
@@ -271,20 +1330,108 @@ func (sz *Reader) readStreamID() error {
 }
 
 func (sz *Reader) discardBlock() error {
-	length := uint64(decodeLength(sz.hdr[1:]))
-	_, err := noeof64(io.CopyN(ioutil.Discard, sz.reader, int64(length)))
+	return sz.inspectBlock(nil)
+}
+
+// inspectBlock skips the data of the current chunk as discardBlock does and,
+// if p is not nil, copies the data to p, which must be as long as the chunk's
+// data.  Chunks which sz parses but does not decode, such as a Header, are
+// read with inspectBlock so that they are subject to the skip limit and
+// discard sink like any other skipped chunk.
+func (sz *Reader) inspectBlock(p []byte) error {
+	length := int64(decodeLength(sz.hdr[1:]))
+	limit := int64(sz.opts.skipLimit)
+	if limit <= 0 {
+		limit = maxBlockSize
+	}
+	var off int64
+	for length > 0 {
+		n := min64(length, limit)
+		if sz.opts.skipPace != nil {
+			err := sz.opts.skipPace(int(n))
+			if err != nil {
+				return err
+			}
+		}
+		var err error
+		if p != nil {
+			err = sz.discardCopy(p[off : off+n])
+		} else {
+			err = sz.discard(n)
+		}
+		if err != nil {
+			return err
+		}
+		off += n
+		length -= n
+	}
+	return nil
+}
+
+// discard reads length bytes of chunk data from the underlying reader and
+// writes them to the discard sink, if there is one and it has not reached its
+// limit.
+func (sz *Reader) discard(length int64) error {
+	if sz.opts.discardSink != nil && sz.discarded < sz.opts.discardMax {
+		n := sz.opts.discardMax - sz.discarded
+		if n > length {
+			n = length
+		}
+		m, err := noeof64(io.CopyN(discardSinkWriter{sz.opts.discardSink}, sz.reader, n))
+		sz.discarded += m
+		length -= m
+		if err != nil {
+			return err
+		}
+	}
+	_, err := noeof64(io.CopyN(ioutil.Discard, sz.reader, length))
 	return err
 }
 
+// discardCopy is like discard but reads len(p) bytes of chunk data into p.
+func (sz *Reader) discardCopy(p []byte) error {
+	_, err := noeof(io.ReadFull(sz.reader, p))
+	if err != nil {
+		return err
+	}
+	if sz.opts.discardSink != nil && sz.discarded < sz.opts.discardMax {
+		n := sz.opts.discardMax - sz.discarded
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		m, err := discardSinkWriter{sz.opts.discardSink}.Write(p[:n])
+		sz.discarded += int64(m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// discardSinkWriter adds context to errors writing to a discard sink.
+type discardSinkWriter struct {
+	w io.Writer
+}
+
+func (w discardSinkWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	if err != nil {
+		err = fmt.Errorf("writing discarded chunk: %w", err)
+	}
+	return n, err
+}
+
 func (sz *Reader) readBlock() ([]byte, error) {
 	// check bounds on encoded length (+4 for checksum)
 	length := decodeLength(sz.hdr[1:])
 	if length > (maxEncodedBlockSize + 4) {
-		return nil, fmt.Errorf("encoded block data too large %d > %d", length, (maxEncodedBlockSize + 4))
+		return nil, fmt.Errorf("%w: encoded length %d > %d", ErrBlockTooLarge, length, maxEncodedBlockSize+4)
 	}
 
 	if int(length) > len(sz.src) {
-		sz.src = make([]byte, length)
+		// grow to the largest possible block so that blocks of fluctuating
+		// size do not cause repeated allocations.
+		sz.src = make([]byte, maxEncodedBlockSize+4)
 	}
 
 	buf := sz.src[:length]
@@ -296,6 +1443,13 @@ func (sz *Reader) readBlock() ([]byte, error) {
 	return buf, nil
 }
 
+// isSkippable returns true if chunks of type typ may be skipped by a reader
+// which does not understand them (4.4 Padding, and 4.6 Reserved skippable
+// chunks).
+func isSkippable(typ byte) bool {
+	return typ == blockPadding || (0x80 <= typ && typ <= 0xfd)
+}
+
 // decodeLength decodes a 24-bit (3-byte) little-endian length from b.
 func decodeLength(b []byte) uint32 {
 	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
@@ -3,11 +3,17 @@ package snappyframed
 import (
 	"bytes"
 	"crypto/rand"
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/golang/snappy"
 )
@@ -92,6 +98,331 @@ func TestReader_unskippable(t *testing.T) {
 	}
 }
 
+// This test checks the callback set with OnUnknownUnskippable both skipping
+// and rejecting reserved unskippable chunks.
+func TestReaderOnUnknownUnskippable(t *testing.T) {
+	stream := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, []byte("forward ")),
+		opaqueChunk(0x50, 100),
+		compressedChunk(t, []byte("compatible")),
+		opaqueChunk(0x03, 10),
+	}, nil)
+
+	var types []byte
+	var lengths []int
+	r := NewReader(bytes.NewReader(stream))
+	r.OnUnknownUnskippable(func(typ byte, data []byte) error {
+		types = append(types, typ)
+		lengths = append(lengths, len(data))
+		return nil
+	})
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "forward compatible" {
+		t.Fatalf("read: %q", p)
+	}
+	if !bytes.Equal(types, []byte{0x50, 0x03}) {
+		t.Fatalf("types: %x", types)
+	}
+	if !reflect.DeepEqual(lengths, []int{100, 10}) {
+		t.Fatalf("lengths: %v", lengths)
+	}
+
+	errAbort := errors.New("abort")
+	r = NewReader(bytes.NewReader(stream))
+	r.OnUnknownUnskippable(func(typ byte, data []byte) error {
+		return errAbort
+	})
+	p, err = ioutil.ReadAll(r)
+	if err != errAbort {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "forward " {
+		t.Fatalf("read before abort: %q", p)
+	}
+
+	// a nil callback restores the default.
+	r.Reset(bytes.NewReader(stream))
+	r.OnUnknownUnskippable(nil)
+	_, err = ioutil.ReadAll(r)
+	if err == nil || !strings.Contains(err.Error(), "unskippable") {
+		t.Fatalf("read without callback: %v", err)
+	}
+}
+
+// This test pins the range of chunk lengths accepted by a Reader.  The longest
+// chunk read into memory holds a checksum and the largest possible encoding of
+// MaxBlockSize bytes.
+func TestReader_maxChunkLength(t *testing.T) {
+	max := int(maxEncodedBlockSize) + 4
+	for _, length := range []int{max, max + 1} {
+		chunk := make([]byte, ChunkHeaderSize+length)
+		EncodeHeader(chunk, 0x02, chunk[ChunkHeaderSize:], nil)
+		if n := int(decodeLength(chunk[1:])); n != length {
+			t.Fatalf("header length %d (!= %d)", n, length)
+		}
+		stream := bytes.Join([][]byte{streamID, chunk}, nil)
+
+		var got int
+		r := NewReader(bytes.NewReader(stream))
+		r.OnUnknownUnskippable(func(typ byte, data []byte) error {
+			got = len(data)
+			return nil
+		})
+		_, err := ioutil.ReadAll(r)
+		if length == max {
+			if err != nil || got != length {
+				t.Errorf("length %d: read %d bytes: %v", length, got, err)
+			}
+		} else if !errors.Is(err, ErrBlockTooLarge) {
+			t.Errorf("length %d: %v", length, err)
+		}
+	}
+
+	// a data block which decodes to more than MaxBlockSize bytes.
+	chunk := make([]byte, blockHeaderSize+MaxBlockSize+1)
+	writeHeader(chunk, blockUncompressed, chunk[blockHeaderSize:], 0)
+	stream := bytes.Join([][]byte{streamID, chunk}, nil)
+	_, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if !errors.Is(err, ErrBlockTooLarge) {
+		t.Errorf("decoded length %d: %v", MaxBlockSize+1, err)
+	}
+}
+
+// This test checks that blocks carrying unmasked checksums are rejected unless
+// the Reader accepts them with AcceptUnmaskedChecksums.
+func TestReaderAcceptUnmaskedChecksums(t *testing.T) {
+	data := []byte("a nonconformant block")
+	enc := snappy.Encode(nil, data)
+	chunk := make([]byte, 8+len(enc))
+	writeHeader(chunk, blockCompressed, enc, crc32.Checksum(data, crcTable))
+	copy(chunk[8:], enc)
+	stream := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, []byte("conformant, ")),
+		chunk,
+	}, nil)
+
+	r := NewReader(bytes.NewReader(stream))
+	_, err := ioutil.ReadAll(r)
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("strict read: %v", err)
+	}
+
+	r.Reset(bytes.NewReader(stream))
+	r.AcceptUnmaskedChecksums(true)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("lenient read: %v", err)
+	}
+	if string(p) != "conformant, a nonconformant block" {
+		t.Fatalf("lenient read: %q", p)
+	}
+
+	// corrupt data is still rejected.
+	corrupt := append([]byte(nil), stream...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	r.Reset(bytes.NewReader(corrupt))
+	_, err = ioutil.ReadAll(r)
+	if err == nil {
+		t.Fatalf("lenient read of corrupt data succeeded")
+	}
+}
+
+// This test checks that a large padding chunk is skipped in segments bounded
+// by SetSkipLimit and that the pacing function can interrupt skipping.
+func TestReaderSetSkipLimit(t *testing.T) {
+	const limit = 10000
+	stream := bytes.Join([][]byte{
+		streamID,
+		opaqueChunk(blockPadding, 1<<20),
+		compressedChunk(t, []byte("after padding")),
+	}, nil)
+
+	var segments []int
+	src := &maxReadReader{r: bytes.NewReader(stream)}
+	r := NewReader(src)
+	r.SetSkipLimit(limit, func(n int) error {
+		segments = append(segments, n)
+		return nil
+	})
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "after padding" {
+		t.Fatalf("read: %q", p)
+	}
+	total := 0
+	for _, n := range segments {
+		if n > limit {
+			t.Fatalf("segment of %d bytes", n)
+		}
+		total += n
+	}
+	if total != 1<<20 || len(segments) != (1<<20+limit-1)/limit {
+		t.Fatalf("%d segments totaling %d bytes", len(segments), total)
+	}
+	if src.max > limit {
+		t.Fatalf("read %d bytes at once", src.max)
+	}
+
+	errPace := errors.New("rate limited")
+	r.Reset(bytes.NewReader(stream))
+	r.SetSkipLimit(0, func(n int) error {
+		if n > MaxBlockSize {
+			t.Errorf("default segment of %d bytes", n)
+		}
+		return errPace
+	})
+	_, err = ioutil.ReadAll(r)
+	if err != errPace {
+		t.Fatalf("paced read: %v", err)
+	}
+}
+
+// This test checks that SetMaxNonDataChunks bounds the number of empty padding
+// chunks read between data blocks.
+func TestReaderSetMaxNonDataChunks(t *testing.T) {
+	const limit = 100
+	stream := func(padding int) []byte {
+		chunks := [][]byte{streamID}
+		for i := 0; i < padding; i++ {
+			chunks = append(chunks, flushMarker)
+		}
+		chunks = append(chunks, compressedChunk(t, []byte("data")))
+		for i := 0; i < padding; i++ {
+			chunks = append(chunks, flushMarker)
+		}
+		return bytes.Join(chunks, nil)
+	}
+
+	// the stream identifier counts toward the limit before the first block.
+	r := NewReader(bytes.NewReader(stream(limit - 1)))
+	r.SetMaxNonDataChunks(limit)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "data" {
+		t.Fatalf("read: %q", p)
+	}
+
+	r.Reset(bytes.NewReader(stream(limit)))
+	_, err = ioutil.ReadAll(r)
+	if err != ErrNonDataChunkLimit {
+		t.Fatalf("read: %v", err)
+	}
+
+	r.SetMaxNonDataChunks(0)
+	r.Reset(bytes.NewReader(stream(10 * limit)))
+	p, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unlimited: %v", err)
+	}
+	if string(p) != "data" {
+		t.Fatalf("unlimited: %q", p)
+	}
+}
+
+// maxReadReader records the largest number of bytes returned by one Read.
+type maxReadReader struct {
+	r   io.Reader
+	max int
+}
+
+func (r *maxReadReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > r.max {
+		r.max = n
+	}
+	return n, err
+}
+
+// This test checks that ExpectDecodedLen detects streams which are shorter or
+// longer than expected.
+func TestReaderExpectDecodedLen(t *testing.T) {
+	first := []byte("the first block, ")
+	second := []byte("the second block")
+	stream := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, first),
+		compressedChunk(t, second),
+	}, nil)
+	total := int64(len(first) + len(second))
+
+	for _, test := range []struct {
+		stream []byte
+		expect int64
+		err    error
+		n      int
+	}{
+		{stream, total, nil, int(total)},
+		{stream, -1, nil, int(total)},
+		{stream, total + 1, ErrLengthMismatch, int(total)},
+		{stream, total - 1, ErrLengthMismatch, len(first)},
+		{stream[:len(stream)-len(compressedChunk(t, second))], total, ErrLengthMismatch, len(first)},
+		{nil, 0, nil, 0},
+		{nil, 1, ErrLengthMismatch, 0},
+	} {
+		r := NewReader(bytes.NewReader(test.stream))
+		r.ExpectDecodedLen(test.expect)
+		var buf bytes.Buffer
+		n, err := r.WriteTo(&buf)
+		if err != test.err {
+			t.Errorf("%d bytes, expect %d: %v (!= %v)", len(test.stream), test.expect, err, test.err)
+		}
+		if n != int64(test.n) {
+			t.Errorf("%d bytes, expect %d: decoded %d bytes (!= %d)", len(test.stream), test.expect, n, test.n)
+		}
+
+		// Read reports the same error after the decoded data.
+		r.Reset(bytes.NewReader(test.stream))
+		p, err := ioutil.ReadAll(r)
+		if err != test.err || len(p) != test.n {
+			t.Errorf("%d bytes, expect %d: read %d bytes: %v", len(test.stream), test.expect, len(p), err)
+		}
+	}
+}
+
+// This test checks the fields of the error describing a corrupt compressed
+// block.
+func TestReader_checksumError(t *testing.T) {
+	first := compressedChunk(t, []byte("an intact block"))
+	data := []byte("a corrupt block")
+	second := compressedChunk(t, data)
+	second[len(second)-1] ^= 0x20 // a literal byte
+	stream := bytes.Join([][]byte{streamID, first, second}, nil)
+
+	_, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("read: %v", err)
+	}
+	var cerr *ChecksumError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("read: %T", err)
+	}
+	corrupt := append([]byte(nil), data...)
+	corrupt[len(corrupt)-1] ^= 0x20
+	expect := ChecksumError{
+		Offset:     int64(len(streamID) + len(first)),
+		Length:     int64(len(second)),
+		Compressed: true,
+		DecodedLen: len(data),
+		Checksum:   crc(data),
+		Actual:     crc(corrupt),
+	}
+	if *cerr != expect {
+		t.Fatalf("error: %+v (!= %+v)", *cerr, expect)
+	}
+	if !strings.Contains(err.Error(), "compressed block at bytes") {
+		t.Fatalf("error: %v", err)
+	}
+}
+
 func TestReaderStreamID(t *testing.T) {
 	data := []byte("a snappy-framed data stream")
 	var buf bytes.Buffer
@@ -465,7 +796,7 @@ func compressedChunk(t *testing.T, src []byte) []byte {
 	}
 
 	chunk := make([]byte, len(encoded)+8)
-	writeHeader(chunk[:8], blockCompressed, encoded, src)
+	writeHeader(chunk[:8], blockCompressed, encoded, crc(src))
 	copy(chunk[8:], encoded)
 	return chunk
 }
@@ -480,7 +811,7 @@ func uncompressedChunk(t *testing.T, src []byte) []byte {
 	}
 
 	chunk := make([]byte, len(src)+8)
-	writeHeader(chunk[:8], blockUncompressed, src, src)
+	writeHeader(chunk[:8], blockUncompressed, src, crc(src))
 	copy(chunk[8:], src)
 	return chunk
 }
@@ -681,3 +1012,1602 @@ func encodedString(s string) io.Reader {
 	w.Flush()
 	return &buf
 }
+
+// This test ensures that a Reader created with NewReaderSize does not allocate
+// while decoding full-size blocks.
+func TestNewReaderSize(t *testing.T) {
+	const numBlocks = 20
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	p := bytes.Repeat(testDataJSON, maxBlockSize/len(testDataJSON)+1)[:maxBlockSize]
+	for i := 0; i < numBlocks; i++ {
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	random := make([]byte, maxBlockSize)
+	_, err := rand.Read(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < numBlocks; i++ {
+		_, err := w.Write(random)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	r := NewReaderSize(bytes.NewReader(buf.Bytes()), int(maxEncodedBlockSize+4))
+	allocs := testing.AllocsPerRun(2*numBlocks-1, func() {
+		n, err := r.nextFrame(ioutil.Discard)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if n != maxBlockSize {
+			t.Fatalf("short block: %d", n)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("%g allocations per block", allocs)
+	}
+
+	r = NewReaderSize(nil, 1<<30)
+	if len(r.src) != int(maxEncodedBlockSize+4) {
+		t.Errorf("src buffer not clamped: %d", len(r.src))
+	}
+	if len(r.dst) != maxBlockSize {
+		t.Errorf("dst buffer not clamped: %d", len(r.dst))
+	}
+}
+
+// fluctuatingStream returns a stream of compressed and uncompressed blocks
+// whose sizes alternate between small and increasingly large, along with the
+// sizes of its blocks.
+func fluctuatingStream(t testing.TB) ([]byte, []int) {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	random := randBytes(t, maxBlockSize)
+	compressible := bytes.Repeat(testDataJSON, maxBlockSize/len(testDataJSON)+1)[:maxBlockSize]
+	var sizes []int
+	for _, n := range []int{maxBlockSize / 2, 100, maxBlockSize - 1000, 100, maxBlockSize - 500, 100, maxBlockSize} {
+		for _, p := range [][]byte{random[:n], compressible[:n]} {
+			_, err := w.Write(p)
+			if err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			sizes = append(sizes, n)
+		}
+	}
+	return buf.Bytes(), sizes
+}
+
+// This test checks that a Reader grows its buffers once, rather than for each
+// larger block, when block sizes fluctuate.
+func TestReader_fluctuatingBlocks(t *testing.T) {
+	stream, sizes := fluctuatingStream(t)
+	r := NewReader(bytes.NewReader(stream))
+	i := 0
+	read := func() {
+		n, err := r.nextFrame(ioutil.Discard)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if n != sizes[i] {
+			t.Fatalf("block %d: %d bytes (!= %d)", i, n, sizes[i])
+		}
+		i++
+	}
+	// the first compressed and uncompressed blocks grow the buffers.
+	read()
+	read()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	mallocs := mem.Mallocs
+	for i < len(sizes) {
+		read()
+	}
+	runtime.ReadMemStats(&mem)
+	if n := mem.Mallocs - mallocs; n != 0 {
+		t.Errorf("%d allocations in %d blocks", n, len(sizes)-2)
+	}
+}
+
+func BenchmarkReaderFluctuating(b *testing.B) {
+	stream, sizes := fluctuatingStream(b)
+	var total int
+	for _, n := range sizes {
+		total += n
+	}
+	r := NewReader(nil)
+	br := bytes.NewReader(stream)
+	b.SetBytes(int64(total))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		br.Reset(stream)
+		r.Reset(br)
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != int64(total) {
+			b.Fatalf("read wrong amount %d != %d", n, total)
+		}
+	}
+}
+
+// This test checks that reading a stream one byte at a time produces the same
+// data as decoding it in full.
+func TestReaderReadByte(t *testing.T) {
+	enc, err := encodeStreamBytes(testDataMan, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	var _ io.ByteReader = NewReader(nil)
+
+	r := NewReader(bytes.NewReader(enc))
+	var dec []byte
+	for {
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("read byte %d: %v", len(dec), err)
+		}
+		dec = append(dec, c)
+	}
+	if !bytes.Equal(dec, testDataMan) {
+		t.Fatalf("unequal decoded content")
+	}
+
+	_, err = r.ReadByte()
+	if err != io.EOF {
+		t.Fatalf("read byte after eof: %v", err)
+	}
+}
+
+// This test checks the reader's handling of chunks following the final data
+// block in a stream.
+func TestReader_trailingChunks(t *testing.T) {
+	data := []byte("trailing chunks")
+	padded := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, data),
+		opaqueChunk(0xfe, 100),
+		opaqueChunk(0xfe, 4),
+	}, nil)
+	p, err := ioutil.ReadAll(NewReader(bytes.NewReader(padded)))
+	if err != nil {
+		t.Fatalf("read padded: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("read padded: unexpected content %q", p)
+	}
+	var buf bytes.Buffer
+	_, err = NewReader(bytes.NewReader(padded)).WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("copy padded: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("copy padded: unexpected content %q", buf.Bytes())
+	}
+
+	garbage := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, data),
+		opaqueChunk(0x02, 100),
+	}, nil)
+	p, err = ioutil.ReadAll(NewReader(bytes.NewReader(garbage)))
+	if err == nil {
+		t.Fatalf("read garbage: expected error")
+	}
+	if err == io.ErrUnexpectedEOF || !strings.Contains(err.Error(), "unskippable") {
+		t.Fatalf("read garbage: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("read garbage: unexpected content %q", p)
+	}
+	buf.Reset()
+	_, err = NewReader(bytes.NewReader(garbage)).WriteTo(&buf)
+	if err == nil {
+		t.Fatalf("copy garbage: expected error")
+	}
+	if err == io.ErrUnexpectedEOF || !strings.Contains(err.Error(), "unskippable") {
+		t.Fatalf("copy garbage: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("copy garbage: unexpected content %q", buf.Bytes())
+	}
+}
+
+// This test checks that a stream truncated inside a chunk header results in
+// io.ErrUnexpectedEOF after any preceding data has been read.
+func TestReader_truncatedHeader(t *testing.T) {
+	data := []byte("truncated header")
+	chunk := compressedChunk(t, data)
+	for i := 1; i < 4; i++ {
+		stream := bytes.Join([][]byte{
+			streamID,
+			chunk,
+			chunk[:i],
+		}, nil)
+
+		p, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("read %d header bytes: %v", i, err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Errorf("read %d header bytes: unexpected content %q", i, p)
+		}
+
+		var buf bytes.Buffer
+		_, err = NewReader(bytes.NewReader(stream)).WriteTo(&buf)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("copy %d header bytes: %v", i, err)
+		}
+		if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("copy %d header bytes: unexpected content %q", i, buf.Bytes())
+		}
+	}
+}
+
+// This test checks that a Reader stops decoding once the total decoded data
+// would exceed the limit set with SetMaxDecodedBytes.
+func TestReaderSetMaxDecodedBytes(t *testing.T) {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	_, err := w.Write(make([]byte, 4*maxBlockSize))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	enc := buf.Bytes()
+
+	for _, test := range []struct {
+		limit int64
+		n     int64
+		err   error
+	}{
+		{0, 4 * maxBlockSize, nil},
+		{4 * maxBlockSize, 4 * maxBlockSize, nil},
+		{4*maxBlockSize - 1, 3 * maxBlockSize, ErrDecodedLimitExceeded},
+		{2 * maxBlockSize, 2 * maxBlockSize, ErrDecodedLimitExceeded},
+		{1, 0, ErrDecodedLimitExceeded},
+	} {
+		r := NewReader(bytes.NewReader(enc))
+		r.SetMaxDecodedBytes(test.limit)
+		p, err := ioutil.ReadAll(r)
+		if err != test.err {
+			t.Errorf("read limit %d: %v (!= %v)", test.limit, err, test.err)
+		}
+		if int64(len(p)) != test.n {
+			t.Errorf("read limit %d: read %d bytes (!= %d)", test.limit, len(p), test.n)
+		}
+
+		r = NewReader(bytes.NewReader(enc))
+		r.SetMaxDecodedBytes(test.limit)
+		n, err := r.WriteTo(ioutil.Discard)
+		if err != test.err {
+			t.Errorf("copy limit %d: %v (!= %v)", test.limit, err, test.err)
+		}
+		if n != test.n {
+			t.Errorf("copy limit %d: wrote %d bytes (!= %d)", test.limit, n, test.n)
+		}
+	}
+}
+
+// This test checks that a closed Reader cannot be read until it is Reset.
+func TestReaderClose(t *testing.T) {
+	enc, err := encodeStreamBytes(testDataMan, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	var _ io.ReadCloser = NewReader(nil)
+
+	r := NewReader(bytes.NewReader(enc))
+	_, err = r.Read(make([]byte, 10))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	n, err := r.Read(make([]byte, 10))
+	if err != ErrReaderClosed {
+		t.Fatalf("read after close: %v", err)
+	}
+	if n != 0 {
+		t.Fatalf("read after close: read %d bytes", n)
+	}
+	_, err = r.WriteTo(ioutil.Discard)
+	if err != ErrReaderClosed {
+		t.Fatalf("copy after close: %v", err)
+	}
+	_, err = r.ReadByte()
+	if err != ErrReaderClosed {
+		t.Fatalf("read byte after close: %v", err)
+	}
+	err = r.Close()
+	if err != ErrReaderClosed {
+		t.Fatalf("close after close: %v", err)
+	}
+
+	r.Reset(bytes.NewReader(enc))
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read after reset: %v", err)
+	}
+	if !bytes.Equal(p, testDataMan) {
+		t.Fatalf("read after reset: unexpected content")
+	}
+}
+
+// This test checks that streams missing a stream identifier are decoded only
+// when allowed.
+func TestReaderAllowMissingStreamID(t *testing.T) {
+	stream := bytes.Join([][]byte{
+		compressedChunk(t, []byte("no stream")),
+		opaqueChunk(0xfe, 10),
+		uncompressedChunk(t, []byte(" identifier")),
+	}, nil)
+
+	r := NewReader(bytes.NewReader(stream))
+	_, err := ioutil.ReadAll(r)
+	if err != errMissingStreamID {
+		t.Fatalf("strict read: %v", err)
+	}
+
+	r = NewReader(bytes.NewReader(stream))
+	r.AllowMissingStreamID(true)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("lenient read: %v", err)
+	}
+	if string(p) != "no stream identifier" {
+		t.Fatalf("lenient read: unexpected content %q", p)
+	}
+
+	// checksums are still verified
+	corrupt := compressedChunk(t, []byte("corrupt"))
+	corrupt[4] ^= 0xff
+	r = NewReader(bytes.NewReader(corrupt))
+	r.AllowMissingStreamID(true)
+	_, err = ioutil.ReadAll(r)
+	if err == nil {
+		t.Fatalf("lenient read: expected checksum error")
+	}
+}
+
+// This test checks that a stream truncated inside its final block is an error
+// unless truncation is allowed, in which case the partial block is dropped.
+func TestReaderAllowTruncation(t *testing.T) {
+	last := compressedChunk(t, bytes.Repeat([]byte("lost"), 100))
+	stream := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, []byte("complete ")),
+		uncompressedChunk(t, []byte("blocks")),
+		last[:len(last)-5],
+	}, nil)
+
+	r := NewReader(bytes.NewReader(stream))
+	p, err := ioutil.ReadAll(r)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("strict read: %v", err)
+	}
+
+	r = NewReader(bytes.NewReader(stream))
+	r.AllowTruncation(true)
+	p, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("lenient read: %v", err)
+	}
+	if string(p) != "complete blocks" {
+		t.Fatalf("lenient read: unexpected content %q", p)
+	}
+
+	// truncation inside a chunk header
+	r = NewReader(bytes.NewReader(stream[:len(stream)-len(last)+5+2]))
+	r.AllowTruncation(true)
+	var buf bytes.Buffer
+	_, err = r.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("lenient write: %v", err)
+	}
+	if buf.String() != "complete blocks" {
+		t.Fatalf("lenient write: unexpected content %q", buf.Bytes())
+	}
+}
+
+// This test checks that Reset clears stream state but retains configuration.
+func TestReaderReset_options(t *testing.T) {
+	data := make([]byte, 2*maxBlockSize)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	noID := bytes.TrimPrefix(enc, streamID)
+
+	r := NewReader(bytes.NewReader(enc))
+	r.SetMaxDecodedBytes(maxBlockSize)
+	r.AllowMissingStreamID(true)
+	opts := r.opts
+
+	_, err = ioutil.ReadAll(r)
+	if err != ErrDecodedLimitExceeded {
+		t.Fatalf("read: %v", err)
+	}
+	if !r.seenStreamID {
+		t.Fatalf("stream identifier not seen")
+	}
+
+	r.Reset(bytes.NewReader(noID))
+	if !reflect.DeepEqual(r.opts, opts) {
+		t.Fatalf("options not retained: %+v (!= %+v)", r.opts, opts)
+	}
+	if r.seenStreamID {
+		t.Fatalf("stream identifier seen after reset")
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != ErrDecodedLimitExceeded {
+		t.Fatalf("read after reset: %v", err)
+	}
+	if len(p) != maxBlockSize {
+		t.Fatalf("read after reset: read %d bytes", len(p))
+	}
+
+	// a stream missing its identifier must not be accepted because a previous
+	// stream had one.
+	r = NewReader(bytes.NewReader(enc))
+	_, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	r.Reset(bytes.NewReader(noID))
+	_, err = ioutil.ReadAll(r)
+	if err != errMissingStreamID {
+		t.Fatalf("read missing stream identifier after reset: %v", err)
+	}
+}
+
+// This test checks that a Reader decodes blocks using buffers provided with
+// SetScratch.
+func TestReaderSetScratch(t *testing.T) {
+	const numBlocks = 10
+	p := bytes.Repeat(testDataJSON, maxBlockSize/len(testDataJSON)+1)[:maxBlockSize]
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	for i := 0; i < numBlocks; i++ {
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	src := make([]byte, 0, maxEncodedBlockSize+4)
+	dst := make([]byte, 0, maxBlockSize)
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetScratch(src, dst)
+	var dec bytes.Buffer
+	dec.Grow(numBlocks * maxBlockSize)
+	allocs := testing.AllocsPerRun(numBlocks-1, func() {
+		_, err := r.nextFrame(&dec)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("%g allocations per block", allocs)
+	}
+	if &r.src[0] != &src[:1][0] {
+		t.Errorf("src buffer was reallocated")
+	}
+	if &r.dst[0] != &dst[:1][0] {
+		t.Errorf("dst buffer was reallocated")
+	}
+	if !bytes.Equal(dec.Bytes(), bytes.Repeat(p, numBlocks)) {
+		t.Errorf("unequal decoded content")
+	}
+
+	// small scratch buffers are grown as needed
+	r = NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetScratch(make([]byte, 16), make([]byte, 16))
+	pdec, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(pdec, bytes.Repeat(p, numBlocks)) {
+		t.Errorf("unequal decoded content")
+	}
+}
+
+// This test checks that blocks which do not decode to their declared length
+// are rejected.
+func TestReader_decodedLength(t *testing.T) {
+	data := []byte("a block with an inconsistent length")
+
+	// declare a decoded length one byte longer than the data.
+	encoded := snappy.Encode(nil, data)
+	inconsistent := append([]byte{byte(len(data) + 1)}, encoded[1:]...)
+	chunk := make([]byte, len(inconsistent)+8)
+	writeHeader(chunk[:8], blockCompressed, inconsistent, crc(data))
+	copy(chunk[8:], inconsistent)
+	stream := bytes.Join([][]byte{streamID, chunk}, nil)
+	_, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err == nil {
+		t.Fatalf("read: expected error")
+	}
+
+	// simulate a decoder which silently produces less data than declared.
+	defer func(decode func(dst, src []byte) ([]byte, error)) { snappyDecode = decode }(snappyDecode)
+	snappyDecode = func(dst, src []byte) ([]byte, error) {
+		dec, err := snappy.Decode(dst, src)
+		if len(dec) > 0 {
+			dec = dec[:len(dec)-1]
+		}
+		return dec, err
+	}
+	stream = bytes.Join([][]byte{streamID, compressedChunk(t, data)}, nil)
+	_, err = ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != ErrDecodedLength {
+		t.Fatalf("read: %v", err)
+	}
+}
+
+// This test checks that a block which decodes to more data than it declares
+// is rejected without growing the Reader's buffer.
+func TestReader_decodedLengthOverrun(t *testing.T) {
+	data := []byte("a block with an inconsistent length")
+
+	// declare a decoded length one byte shorter than the data.
+	encoded := snappy.Encode(nil, data)
+	inconsistent := append([]byte{byte(len(data) - 1)}, encoded[1:]...)
+	chunk := make([]byte, len(inconsistent)+8)
+	writeHeader(chunk[:8], blockCompressed, inconsistent, crc(data[:len(data)-1]))
+	copy(chunk[8:], inconsistent)
+	stream := bytes.Join([][]byte{streamID, chunk}, nil)
+	_, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err == nil {
+		t.Fatalf("read: expected error")
+	}
+
+	// simulate a decoder which ignores the declared length and allocates.
+	defer func(decode func(dst, src []byte) ([]byte, error)) { snappyDecode = decode }(snappyDecode)
+	snappyDecode = func(dst, src []byte) ([]byte, error) {
+		return make([]byte, 2*maxBlockSize), nil
+	}
+	stream = bytes.Join([][]byte{streamID, compressedChunk(t, data)}, nil)
+	r := NewReader(bytes.NewReader(stream))
+	_, err = ioutil.ReadAll(r)
+	if err != ErrDecodedLength {
+		t.Fatalf("read: %v", err)
+	}
+	if cap(r.dst) > maxBlockSize {
+		t.Fatalf("buffer grew to %d bytes", cap(r.dst))
+	}
+}
+
+// This test checks that WriteTo reports exactly the number of bytes written
+// to a writer which fails part way through the stream, and that the remaining
+// data can be read afterwards.
+func TestReaderWriteTo_writeError(t *testing.T) {
+	data := make([]byte, 3*maxBlockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	errFail := fmt.Errorf("write failed")
+	for _, limit := range []int{0, 10, maxBlockSize, maxBlockSize + 1, 2*maxBlockSize + 7} {
+		r := NewReader(bytes.NewReader(enc))
+		w := &limitWriter{n: limit, err: errFail}
+		n, err := r.WriteTo(w)
+		if err != errFail {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		if n != int64(w.buf.Len()) {
+			t.Fatalf("limit %d: returned %d bytes but wrote %d", limit, n, w.buf.Len())
+		}
+
+		rest, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("limit %d: read: %v", limit, err)
+		}
+		if !bytes.Equal(append(w.buf.Bytes(), rest...), data) {
+			t.Fatalf("limit %d: decoded data differs", limit)
+		}
+	}
+}
+
+// limitWriter is an io.Writer that accepts n bytes and then returns err.
+type limitWriter struct {
+	buf bytes.Buffer
+	n   int
+	err error
+}
+
+func (w *limitWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.buf.Write(p[:w.n])
+		n := w.n
+		w.n = 0
+		return n, w.err
+	}
+	w.n -= len(p)
+	return w.buf.Write(p)
+}
+
+// This test checks that ReadFrame returns the data from each block of a
+// stream in turn.
+func TestReaderReadFrame(t *testing.T) {
+	frames := []string{"first frame", "", "second frame", strings.Repeat("compressed ", 100)}
+	var stream [][]byte
+	stream = append(stream, streamID)
+	for i, frame := range frames {
+		if i%2 == 0 {
+			stream = append(stream, uncompressedChunk(t, []byte(frame)))
+		} else {
+			stream = append(stream, compressedChunk(t, []byte(frame)))
+		}
+		stream = append(stream, opaqueChunk(0xfe, 10))
+	}
+
+	r := NewReader(bytes.NewReader(bytes.Join(stream, nil)))
+	for i, expect := range frames {
+		p, err := r.ReadFrame()
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if string(p) != expect {
+			t.Fatalf("frame %d: %q (!= %q)", i, p, expect)
+		}
+	}
+	_, err := r.ReadFrame()
+	if err != io.EOF {
+		t.Fatalf("expected EOF: %v", err)
+	}
+
+	// data buffered by Read is returned first
+	r = NewReader(bytes.NewReader(bytes.Join(stream, nil)))
+	var buf [6]byte
+	_, err = io.ReadFull(r, buf[:])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	p, err := r.ReadFrame()
+	if err != nil {
+		t.Fatalf("frame after read: %v", err)
+	}
+	if string(p) != "frame" {
+		t.Fatalf("frame after read: %q", p)
+	}
+
+	// checksums are verified
+	corrupt := compressedChunk(t, []byte("corrupt"))
+	corrupt[4] ^= 0xff
+	r = NewReader(bytes.NewReader(append(append([]byte{}, streamID...), corrupt...)))
+	_, err = r.ReadFrame()
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("corrupt frame: %v", err)
+	}
+}
+
+// This test checks that DecodeInto decodes every block into the buffer given
+// to it when the buffer is large enough, and allocates otherwise.
+func TestReaderDecodeInto(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 10)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(enc))
+	dst := make([]byte, MaxBlockSize)
+	var out []byte
+	for {
+		n, block, err := r.DecodeInto(dst)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if n != len(block) || n == 0 {
+			t.Fatalf("decoded %d bytes (%d)", len(block), n)
+		}
+		if &block[0] != &dst[0] {
+			t.Fatalf("block was not decoded into dst")
+		}
+		out = append(out, block...)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decoded data differs")
+	}
+
+	// a small buffer is replaced by a larger one.
+	r.Reset(bytes.NewReader(enc))
+	n, block, err := r.DecodeInto(make([]byte, 10))
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if n != MaxBlockSize || !bytes.Equal(block, data[:n]) {
+		t.Fatalf("decoded %d bytes", n)
+	}
+}
+
+// This test checks that a stream identifier following stream data is ignored
+// in multistream mode and ends the stream otherwise.
+func TestReaderMultistream(t *testing.T) {
+	stream := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, []byte("first stream")),
+		streamID,
+		uncompressedChunk(t, []byte(" second stream")),
+	}, nil)
+
+	p, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("multistream read: %v", err)
+	}
+	if string(p) != "first stream second stream" {
+		t.Fatalf("multistream read: unexpected content %q", p)
+	}
+
+	underlying := bytes.NewReader(stream)
+	r := NewReader(underlying)
+	r.Multistream(false)
+	p, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("single stream read: %v", err)
+	}
+	if string(p) != "first stream" {
+		t.Fatalf("single stream read: unexpected content %q", p)
+	}
+	var buf [1]byte
+	n, err := r.Read(buf[:])
+	if n != 0 || err != io.EOF {
+		t.Fatalf("read after end of stream: %d %v", n, err)
+	}
+
+	// the underlying reader is positioned after the identifier
+	r.Reset(underlying)
+	r.AllowMissingStreamID(true)
+	var out bytes.Buffer
+	_, err = r.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("next stream: %v", err)
+	}
+	if out.String() != " second stream" {
+		t.Fatalf("next stream: unexpected content %q", out.Bytes())
+	}
+}
+
+// This test checks that Discard consumes the remainder of a partially read
+// stream.
+func TestReaderDiscard(t *testing.T) {
+	data := make([]byte, 3*maxBlockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(enc))
+	var buf [1000]byte
+	_, err = io.ReadFull(r, buf[:])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	n, err := r.Discard()
+	if err != nil {
+		t.Fatalf("discard: %v", err)
+	}
+	if n != int64(len(data)-len(buf)) {
+		t.Fatalf("discarded %d bytes (!= %d)", n, len(data)-len(buf))
+	}
+	_, err = r.Read(buf[:])
+	if err != io.EOF {
+		t.Fatalf("read after discard: %v", err)
+	}
+
+	// checksums are verified
+	corrupt := compressedChunk(t, []byte("corrupt"))
+	corrupt[4] ^= 0xff
+	r = NewReader(bytes.NewReader(append(append([]byte{}, streamID...), corrupt...)))
+	_, err = r.Discard()
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("corrupt discard: %v", err)
+	}
+}
+
+// This test checks that data blocks without a checksum and block data are
+// rejected.
+func TestReader_emptyBlock(t *testing.T) {
+	for _, typ := range []byte{blockCompressed, blockUncompressed} {
+		for _, chunk := range [][]byte{
+			{typ, 0, 0, 0},
+			{typ, 4, 0, 0, 0, 0, 0, 0},
+		} {
+			stream := append(append([]byte{}, streamID...), chunk...)
+			_, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+			if err != ErrEmptyBlock {
+				t.Errorf("chunk %x: %v", chunk, err)
+			}
+			_, err = DecodeBlock(nil, chunk)
+			if err != ErrEmptyBlock && len(chunk) >= blockHeaderSize {
+				t.Errorf("decode chunk %x: %v", chunk, err)
+			}
+		}
+	}
+
+	// an empty compressed block is valid
+	stream := append(append([]byte{}, streamID...), compressedChunk(t, nil)...)
+	p, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil || len(p) != 0 {
+		t.Fatalf("empty compressed block: %q %v", p, err)
+	}
+}
+
+// This test checks that skippable chunks may precede the stream identifier
+// only when a leading identifier is not required.
+func TestReaderRequireLeadingStreamID(t *testing.T) {
+	leading := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, []byte("leading")),
+	}, nil)
+	padded := bytes.Join([][]byte{
+		opaqueChunk(0xfe, 10),
+		opaqueChunk(0x90, 10),
+		streamID,
+		compressedChunk(t, []byte("padded")),
+	}, nil)
+	data := bytes.Join([][]byte{
+		opaqueChunk(0xfe, 10),
+		uncompressedChunk(t, []byte("data")),
+		streamID,
+	}, nil)
+
+	for _, require := range []bool{true, false} {
+		r := NewReader(bytes.NewReader(leading))
+		r.RequireLeadingStreamID(require)
+		p, err := ioutil.ReadAll(r)
+		if err != nil || string(p) != "leading" {
+			t.Errorf("require %t: leading identifier: %q %v", require, p, err)
+		}
+
+		r = NewReader(bytes.NewReader(padded))
+		r.RequireLeadingStreamID(require)
+		p, err = ioutil.ReadAll(r)
+		if require && err != errMissingStreamID {
+			t.Errorf("require %t: padded identifier: %v", require, err)
+		}
+		if !require && (err != nil || string(p) != "padded") {
+			t.Errorf("require %t: padded identifier: %q %v", require, p, err)
+		}
+
+		// data preceding the identifier is always an error
+		r = NewReader(bytes.NewReader(data))
+		r.RequireLeadingStreamID(require)
+		_, err = ioutil.ReadAll(r)
+		if err != errMissingStreamID {
+			t.Errorf("require %t: data before identifier: %v", require, err)
+		}
+	}
+}
+
+// This test checks the streams accepted at each level set with SetLeniency.
+func TestReaderSetLeniency(t *testing.T) {
+	padded := bytes.Join([][]byte{
+		opaqueChunk(0x90, 10),
+		streamID,
+		compressedChunk(t, []byte("padded")),
+	}, nil)
+	missing := compressedChunk(t, []byte("missing"))
+
+	for _, test := range []struct {
+		level   Leniency
+		padded  bool
+		missing bool
+	}{
+		{LeniencyNone, false, false},
+		{LeniencyLeadingSkippable, true, false},
+		{LeniencyMissingStreamID, true, true},
+	} {
+		for _, stream := range []struct {
+			data   []byte
+			expect string
+			ok     bool
+		}{
+			{padded, "padded", test.padded},
+			{missing, "missing", test.missing},
+		} {
+			r := NewReader(bytes.NewReader(stream.data))
+			r.SetLeniency(test.level)
+			p, err := ioutil.ReadAll(r)
+			if stream.ok && (err != nil || string(p) != stream.expect) {
+				t.Errorf("level %d: %s: %q %v", test.level, stream.expect, p, err)
+			}
+			if !stream.ok && err != errMissingStreamID {
+				t.Errorf("level %d: %s: %v", test.level, stream.expect, err)
+			}
+		}
+	}
+}
+
+// This test checks that the members of concatenated streams can be read one
+// at a time by resetting a Reader with multistream mode disabled, as with
+// gzip.
+func TestReaderMultistream_members(t *testing.T) {
+	members := []string{"first stream", "second stream", "third stream"}
+	for _, opts := range []*WriterOptions{nil, {StreamChecksum: true}} {
+		var stream bytes.Buffer
+		for _, member := range members {
+			w := NewWriterOptions(&stream, opts)
+			w.Write([]byte(member))
+			err := w.Close()
+			if err != nil {
+				t.Fatalf("close: %v", err)
+			}
+		}
+
+		underlying := bytes.NewReader(stream.Bytes())
+		r := NewReader(underlying)
+		r.Multistream(false)
+		r.VerifyStreamChecksum(opts != nil)
+		for i, member := range members {
+			if i > 0 {
+				r.Reset(underlying)
+			}
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("member %d: %v", i, err)
+			}
+			if string(p) != member {
+				t.Fatalf("member %d: unexpected content %q", i, p)
+			}
+		}
+		r.Reset(underlying)
+		p, err := ioutil.ReadAll(r)
+		if err != nil || len(p) != 0 {
+			t.Fatalf("end of input: %q %v", p, err)
+		}
+	}
+
+	// an identifier consumed from one reader does not begin a stream read
+	// from another.
+	stream := bytes.Join([][]byte{
+		streamID,
+		uncompressedChunk(t, []byte("first stream")),
+		streamID,
+		uncompressedChunk(t, []byte("second stream")),
+	}, nil)
+	underlying := bytes.NewReader(stream)
+	r := NewReader(underlying)
+	r.Multistream(false)
+	_, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("first stream: %v", err)
+	}
+	r.Reset(bytes.NewReader(stream[len(stream)-underlying.Len():]))
+	_, err = ioutil.ReadAll(r)
+	if err != errMissingStreamID {
+		t.Fatalf("other reader: %v", err)
+	}
+}
+
+// This test checks that SetMaxBlockExpansion rejects compressed blocks which
+// expand beyond the limit before decoding them.
+func TestReaderSetMaxBlockExpansion(t *testing.T) {
+	chunk := compressedChunk(t, make([]byte, maxBlockSize))
+	ratio := maxBlockSize / (len(chunk) - blockHeaderSize)
+	stream := bytes.Join([][]byte{
+		streamID,
+		uncompressedChunk(t, []byte("uncompressed")),
+		chunk,
+	}, nil)
+
+	r := NewReader(bytes.NewReader(stream))
+	r.SetMaxBlockExpansion(ratio / 2)
+	p, err := ioutil.ReadAll(r)
+	if err != ErrBlockExpansion {
+		t.Fatalf("read with ratio %d: %v", ratio/2, err)
+	}
+	if string(p) != "uncompressed" {
+		t.Fatalf("read with ratio %d: unexpected content %q", ratio/2, p)
+	}
+
+	// the decoded size is not a multiple of the compressed size so the ratio
+	// rounded down is exceeded.
+	if maxBlockSize%(len(chunk)-blockHeaderSize) != 0 {
+		r = NewReader(bytes.NewReader(stream))
+		r.SetMaxBlockExpansion(ratio)
+		_, err = ioutil.ReadAll(r)
+		if err != ErrBlockExpansion {
+			t.Fatalf("read with ratio %d: %v", ratio, err)
+		}
+	}
+
+	// large ratios must not overflow when multiplied by the compressed size.
+	maxInt := int(^uint(0) >> 1)
+	overflow := maxInt/(len(chunk)-blockHeaderSize) + 1
+	for _, ratio := range []int{ratio + 1, overflow, maxInt} {
+		r = NewReader(bytes.NewReader(stream))
+		r.SetMaxBlockExpansion(ratio)
+		p, err = ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read with ratio %d: %v", ratio, err)
+		}
+		if len(p) != len("uncompressed")+maxBlockSize {
+			t.Fatalf("read with ratio %d: %d bytes", ratio, len(p))
+		}
+	}
+}
+
+// This test checks that Seek skips forward through decoded data.
+func TestReaderSeek(t *testing.T) {
+	data := make([]byte, 3*maxBlockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(enc))
+	var buf [100]byte
+	_, err = io.ReadFull(r, buf[:])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	for _, test := range []struct {
+		offset int64
+		whence int
+		pos    int64
+	}{
+		{0, io.SeekCurrent, 100},
+		{50, io.SeekCurrent, 250},
+		{maxBlockSize, io.SeekStart, maxBlockSize},
+		{maxBlockSize + 3, io.SeekCurrent, 2*maxBlockSize + 103},
+		{3 * maxBlockSize, io.SeekStart, 3 * maxBlockSize},
+	} {
+		pos, err := r.Seek(test.offset, test.whence)
+		if err != nil {
+			t.Fatalf("seek %d %d: %v", test.offset, test.whence, err)
+		}
+		if pos != test.pos {
+			t.Fatalf("seek %d %d: offset %d (!= %d)", test.offset, test.whence, pos, test.pos)
+		}
+		_, err = io.ReadFull(r, buf[:])
+		if err != nil {
+			t.Fatalf("read at %d: %v", pos, err)
+		}
+		if !bytes.Equal(buf[:], data[pos:pos+100]) {
+			t.Fatalf("read at %d: unexpected content", pos)
+		}
+		test.pos += 100
+		if p, _ := r.Seek(0, io.SeekCurrent); p != test.pos {
+			t.Fatalf("offset after read %d (!= %d)", p, test.pos)
+		}
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err == nil {
+		t.Fatalf("backward seek: expected error")
+	}
+	_, err = r.Seek(0, io.SeekEnd)
+	if err == nil {
+		t.Fatalf("seek from end: expected error")
+	}
+	pos, err := r.Seek(int64(len(data)), io.SeekCurrent)
+	if err != io.EOF || pos != int64(len(data)) {
+		t.Fatalf("seek past end: %d %v", pos, err)
+	}
+}
+
+// This test checks that Read returns every decoded byte before io.EOF and
+// returns io.EOF only after the last byte has been delivered.
+func TestReader_EOF(t *testing.T) {
+	hello := bytes.Join([][]byte{streamID, compressedChunk(t, []byte("hello"))}, nil)
+	for _, test := range []struct {
+		name   string
+		stream []byte
+		size   int
+		reads  []string
+	}{
+		{"partial", hello, 3, []string{"hel", "lo"}},
+		{"exact", hello, 5, []string{"hello"}},
+		{"short", hello, 10, []string{"hello"}},
+		{"empty", streamID, 10, nil},
+		{"empty blocks", bytes.Join([][]byte{
+			streamID,
+			compressedChunk(t, []byte("ab")),
+			compressedChunk(t, nil),
+			compressedChunk(t, []byte("cd")),
+			compressedChunk(t, nil),
+		}, nil), 10, []string{"ab", "cd"}},
+	} {
+		r := NewReader(&eofReader{r: bytes.NewReader(test.stream)})
+		buf := make([]byte, test.size)
+		for i, expect := range test.reads {
+			n, err := r.Read(buf)
+			if err != nil {
+				t.Fatalf("%s: read %d: %v", test.name, i, err)
+			}
+			if string(buf[:n]) != expect {
+				t.Fatalf("%s: read %d: %q (!= %q)", test.name, i, buf[:n], expect)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			n, err := r.Read(buf)
+			if n != 0 || err != io.EOF {
+				t.Fatalf("%s: read at end: %d %v", test.name, n, err)
+			}
+		}
+	}
+}
+
+// eofReader is an io.Reader which panics if it is read after returning io.EOF.
+type eofReader struct {
+	r   io.Reader
+	eof bool
+}
+
+func (r *eofReader) Read(p []byte) (int, error) {
+	if r.eof {
+		panic("read after EOF")
+	}
+	n, err := r.r.Read(p)
+	r.eof = err == io.EOF
+	return n, err
+}
+
+// This test checks that discarded chunk data is written to a discard sink up
+// to its limit.
+func TestReaderSetDiscardSink(t *testing.T) {
+	reserved := opaqueChunk(0x90, 20)
+	padding := opaqueChunk(0xfe, 20)
+	stream := bytes.Join([][]byte{
+		streamID,
+		reserved,
+		compressedChunk(t, []byte("data")),
+		padding,
+	}, nil)
+
+	var sink bytes.Buffer
+	r := NewReader(bytes.NewReader(stream))
+	r.SetDiscardSink(&sink, 30)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "data" {
+		t.Fatalf("read: unexpected content %q", p)
+	}
+	expect := append(append([]byte{}, reserved[4:]...), padding[4:14]...)
+	if !bytes.Equal(sink.Bytes(), expect) {
+		t.Fatalf("sink: %x (!= %x)", sink.Bytes(), expect)
+	}
+
+	// the limit applies to each stream
+	sink.Reset()
+	r.Reset(bytes.NewReader(stream))
+	_, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read after reset: %v", err)
+	}
+	if sink.Len() != 30 {
+		t.Fatalf("sink after reset: %d bytes", sink.Len())
+	}
+
+	errFail := fmt.Errorf("sink failed")
+	r = NewReader(bytes.NewReader(stream))
+	r.SetDiscardSink(&writerFailN{err: errFail}, 30)
+	_, err = ioutil.ReadAll(r)
+	if !errors.Is(err, errFail) {
+		t.Fatalf("failing sink: %v", err)
+	}
+}
+
+// This test checks that ReadFull fills a large buffer from a multi-block
+// stream in a single call.
+func TestReaderReadFull(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)[:5*MaxBlockSize/2]
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	stream := buf.Bytes()
+
+	r := NewReader(bytes.NewReader(stream))
+	p := make([]byte, len(data))
+	n, err := r.ReadFull(p)
+	if err != nil {
+		t.Fatalf("read full: %v", err)
+	}
+	if n != len(data) || !bytes.Equal(p, data) {
+		t.Fatalf("read %d bytes (!= %d)", n, len(data))
+	}
+	n, err = r.ReadFull(p)
+	if n != 0 || err != io.EOF {
+		t.Fatalf("read at end of stream: %d %v", n, err)
+	}
+
+	// data buffered by Read and data beyond b are returned by later calls.
+	r.Reset(bytes.NewReader(stream))
+	_, err = io.ReadFull(r, p[:10])
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	n, err = r.ReadFull(p[10 : MaxBlockSize+10])
+	if err != nil || n != MaxBlockSize {
+		t.Fatalf("read full: %d %v", n, err)
+	}
+	q := make([]byte, len(data))
+	n, err = r.ReadFull(q)
+	if err != io.ErrUnexpectedEOF || n != len(data)-MaxBlockSize-10 {
+		t.Fatalf("read full past end: %d %v", n, err)
+	}
+	copy(p[MaxBlockSize+10:], q[:n])
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+	_, err = r.ReadFull(q)
+	if err != io.EOF {
+		t.Fatalf("read after unexpected EOF: %v", err)
+	}
+}
+
+// This test checks that a Reader with SetNoBuffer returns one block per Read
+// and rejects short buffers.
+func TestReaderSetNoBuffer(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)[:5*MaxBlockSize/2]
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+
+	r := NewReader(&buf)
+	r.SetNoBuffer(true)
+	n, err := r.Read(make([]byte, MaxBlockSize-1))
+	if n != 0 || err != io.ErrShortBuffer {
+		t.Fatalf("short read: %d %v", n, err)
+	}
+	p := make([]byte, 2*MaxBlockSize)
+	var dec []byte
+	for _, expect := range []int{MaxBlockSize, MaxBlockSize, MaxBlockSize / 2} {
+		n, err := r.Read(p)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if n != expect {
+			t.Fatalf("read %d bytes (!= %d)", n, expect)
+		}
+		dec = append(dec, p[:n]...)
+	}
+	if r.buf.Len() != 0 {
+		t.Fatalf("%d bytes buffered", r.buf.Len())
+	}
+	n, err = r.Read(p)
+	if n != 0 || err != io.EOF {
+		t.Fatalf("read at end of stream: %d %v", n, err)
+	}
+	if !bytes.Equal(dec, data) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func BenchmarkReaderBuffered(b *testing.B) {
+	benchmarkReaderReads(b, false)
+}
+
+func BenchmarkReaderNoBuffer(b *testing.B) {
+	benchmarkReaderReads(b, true)
+}
+
+// benchmarkReaderReads benchmarks decoding a stream by calling Read with a
+// buffer of MaxBlockSize bytes, optionally bypassing the internal buffer.
+func benchmarkReaderReads(b *testing.B, noBuffer bool) {
+	data := bytes.Repeat(testDataJSON, 10)
+	enc, err := encodeStreamBytes(data, true)
+	if err != nil {
+		b.Fatal(err)
+	}
+	r := NewReader(nil)
+	r.SetNoBuffer(noBuffer)
+	p := make([]byte, MaxBlockSize)
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(bytes.NewReader(enc))
+		var total int
+		for {
+			n, err := r.Read(p)
+			total += n
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		if total != len(data) {
+			b.Fatalf("read wrong amount %d != %d", total, len(data))
+		}
+	}
+}
+
+// This test checks that a hash set with SetPlaintextHash receives all decoded
+// data, whether it is consumed by Read or WriteTo.
+func TestReaderSetPlaintextHash(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Write(data)
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	expect := sha256.Sum256(data)
+
+	for _, test := range []struct {
+		name string
+		read func(r io.Reader) ([]byte, error)
+	}{
+		{"Read", func(r io.Reader) ([]byte, error) { return ioutil.ReadAll(r) }},
+		{"WriteTo", func(r io.Reader) ([]byte, error) {
+			var out bytes.Buffer
+			_, err := r.(io.WriterTo).WriteTo(&out)
+			return out.Bytes(), err
+		}},
+	} {
+		h := sha256.New()
+		r := NewReader(bytes.NewReader(buf.Bytes()))
+		r.SetPlaintextHash(h)
+		p, err := test.read(r)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(p, data) {
+			t.Errorf("%s: decoded data differs", test.name)
+		}
+		if !bytes.Equal(h.Sum(nil), expect[:]) {
+			t.Errorf("%s: hash %x (!= %x)", test.name, h.Sum(nil), expect)
+		}
+	}
+}
+
+// maxWriteWriter records the largest write made to it.
+type maxWriteWriter struct {
+	bytes.Buffer
+	max int
+}
+
+func (w *maxWriteWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	return w.Buffer.Write(p)
+}
+
+// This test checks that a Reader writes the same data through WriteTo after
+// DisableWriteTo, but in pieces read with Read.
+func TestReaderDisableWriteTo(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 10)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	var fast, slow maxWriteWriter
+	for _, w := range []*maxWriteWriter{&fast, &slow} {
+		r := NewReader(bytes.NewReader(enc))
+		if w == &slow {
+			r.DisableWriteTo()
+		}
+		n, err := r.WriteTo(w)
+		if err != nil {
+			t.Fatalf("write to: %v", err)
+		}
+		if n != int64(len(data)) {
+			t.Fatalf("wrote %d bytes (!= %d)", n, len(data))
+		}
+	}
+	if !bytes.Equal(fast.Bytes(), data) || !bytes.Equal(slow.Bytes(), data) {
+		t.Fatalf("decoded data differs")
+	}
+	if fast.max != MaxBlockSize || slow.max >= MaxBlockSize {
+		t.Fatalf("largest writes %d and %d", fast.max, slow.max)
+	}
+}
+
+// This test checks that data following a stream remains readable from the
+// reader returned by Underlying.
+func TestReaderUnderlying(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 2)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	src := bytes.NewReader(append(enc, "extra data"...))
+	r := NewReader(src)
+	if r.Underlying() != src {
+		t.Fatalf("wrong underlying reader")
+	}
+
+	p := make([]byte, len(data))
+	_, err = r.ReadFull(p)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+	extra, err := ioutil.ReadAll(r.Underlying())
+	if err != nil {
+		t.Fatalf("read underlying: %v", err)
+	}
+	if string(extra) != "extra data" {
+		t.Fatalf("underlying data: %q", extra)
+	}
+
+	r.Close()
+	if r.Underlying() != nil {
+		t.Fatalf("underlying reader retained by Close")
+	}
+}
+
+// This test checks that WriteToLimit writes a prefix of the decoded data and
+// that the remainder can be read afterward.
+func TestReaderWriteToLimit(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 4)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	for _, limit := range []int64{0, 10, MaxBlockSize, MaxBlockSize + 100} {
+		r := NewReader(bytes.NewReader(enc))
+		p := make([]byte, 5)
+		_, err := r.ReadFull(p)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		var buf bytes.Buffer
+		n, err := r.WriteToLimit(&buf, limit)
+		if err != nil {
+			t.Fatalf("limit %d: %v", limit, err)
+		}
+		if n != limit || buf.Len() != int(limit) {
+			t.Fatalf("limit %d: wrote %d bytes (%d)", limit, buf.Len(), n)
+		}
+		rest, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("limit %d: read remainder: %v", limit, err)
+		}
+		p = append(append(p, buf.Bytes()...), rest...)
+		if !bytes.Equal(p, data) {
+			t.Fatalf("limit %d: decoded data differs", limit)
+		}
+	}
+
+	r := NewReader(bytes.NewReader(enc))
+	n, err := r.WriteToLimit(ioutil.Discard, int64(2*len(data)))
+	if err != nil {
+		t.Fatalf("short stream: %v", err)
+	}
+	if n != int64(len(data)) {
+		t.Fatalf("short stream: wrote %d bytes (!= %d)", n, len(data))
+	}
+}
+
+// This test checks the errors returned for empty input and for a stream
+// identifier followed by EOF.
+func TestReader_emptyInput(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		input  []byte
+		reject bool
+		err    error
+	}{
+		{"empty", nil, false, io.EOF},
+		{"identifier", streamID, false, io.EOF},
+		{"empty rejected", nil, true, ErrEmptyInput},
+		{"identifier rejected", streamID, true, io.EOF},
+		{"padding rejected", opaqueChunk(blockPadding, 8), true, ErrEmptyInput},
+	} {
+		r := NewReader(bytes.NewReader(test.input))
+		r.RequireLeadingStreamID(false)
+		r.RejectEmptyInput(test.reject)
+		n, err := r.Read(make([]byte, 10))
+		if n != 0 || err != test.err {
+			t.Errorf("%s: read %d bytes: %v (!= %v)", test.name, n, err, test.err)
+		}
+	}
+}
+
+// This test checks that StreamIDSeen reports whether the stream identifier has
+// been read.
+func TestReaderStreamIDSeen(t *testing.T) {
+	stream := bytes.Join([][]byte{streamID, opaqueChunk(blockPadding, 10)}, nil)
+	r := NewReader(bytes.NewReader(stream))
+	if r.StreamIDSeen() {
+		t.Fatalf("identifier seen before reading")
+	}
+	_, err := r.Read(make([]byte, 10))
+	if err != io.EOF {
+		t.Fatalf("read: %v", err)
+	}
+	if !r.StreamIDSeen() {
+		t.Fatalf("identifier not seen")
+	}
+	r.Reset(bytes.NewReader(stream))
+	if r.StreamIDSeen() {
+		t.Fatalf("identifier seen after Reset")
+	}
+}
+
+// finalEOFReader returns io.EOF together with the final bytes of its data instead
+// of from a separate call to Read, as io.Reader permits.
+type finalEOFReader struct {
+	p []byte
+}
+
+func (r *finalEOFReader) Read(p []byte) (int, error) {
+	n := copy(p, r.p)
+	r.p = r.p[n:]
+	if len(r.p) == 0 {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// This test checks that the final chunk of a stream is decoded when the
+// underlying reader returns its data together with io.EOF.
+func TestReader_dataWithEOF(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	padded := append(append([]byte(nil), enc...), opaqueChunk(blockPadding, 10)...)
+
+	for _, test := range []struct {
+		name string
+		r    func() io.Reader
+	}{
+		{"finalEOFReader", func() io.Reader { return &finalEOFReader{enc} }},
+		{"finalEOFReader padded", func() io.Reader { return &finalEOFReader{padded} }},
+		{"DataErrReader", func() io.Reader { return iotest.DataErrReader(bytes.NewReader(enc)) }},
+	} {
+		p, err := ioutil.ReadAll(NewReader(test.r()))
+		if err != nil {
+			t.Errorf("%s: read: %v", test.name, err)
+		} else if !bytes.Equal(p, data) {
+			t.Errorf("%s: decoded data differs", test.name)
+		}
+
+		var buf bytes.Buffer
+		_, err = NewReader(test.r()).WriteTo(&buf)
+		if err != nil {
+			t.Errorf("%s: write to: %v", test.name, err)
+		} else if !bytes.Equal(buf.Bytes(), data) {
+			t.Errorf("%s: written data differs", test.name)
+		}
+	}
+
+	// a truncated final chunk is still an error.
+	_, err = ioutil.ReadAll(NewReader(&finalEOFReader{enc[:len(enc)-1]}))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("truncated: %v", err)
+	}
+}
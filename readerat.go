@@ -0,0 +1,216 @@
+package snappyframed
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// EncodeReaderAt encodes size bytes read from r, starting at offset zero, and
+// writes the result to the underlying io.Writer.  Blocks of MaxBlockSize bytes
+// are read with ReadAt and compressed concurrently, one goroutine per
+// available CPU, but are written in order.  The encoded data is identical to
+// that written by ReadFrom given the same data.  Data buffered by sz is
+// flushed before any data from r is encoded.  EncodeReaderAt returns the
+// number of bytes from r that were encoded and written along with any error
+// encountered.  A read returning fewer bytes than requested results in
+// io.ErrUnexpectedEOF.  Checksums computed by a WriterOptions.Checksum
+// function are computed in order as blocks are written, so the function is
+// never called concurrently.
+func (sz *Writer) EncodeReaderAt(r io.ReaderAt, size int64) (int64, error) {
+	if sz.err != nil {
+		return 0, sz.err
+	}
+	if sz.w.writer == nil {
+		return 0, ErrNilTarget
+	}
+	sz.err = sz.bw.Flush()
+	if sz.err != nil {
+		return 0, sz.err
+	}
+	if size <= 0 {
+		return 0, nil
+	}
+	sz.err = sz.w.writeStreamID()
+	if sz.err != nil {
+		return 0, sz.err
+	}
+
+	nworker := runtime.GOMAXPROCS(0)
+	if nblock := (size + maxBlockSize - 1) / maxBlockSize; int64(nworker) > nblock {
+		nworker = int(nblock)
+	}
+	blocks := make([]encodedBlock, nworker)
+	for i := range blocks {
+		blocks[i].src = make([]byte, maxBlockSize)
+	}
+
+	var total int64
+	var wg sync.WaitGroup
+	for off := int64(0); off < size; {
+		// read and encode a batch of consecutive blocks concurrently.
+		k := 0
+		for ; k < len(blocks) && off < size; k++ {
+			n := int64(maxBlockSize)
+			if size-off < n {
+				n = size - off
+			}
+			blocks[k].off = off
+			blocks[k].src = blocks[k].src[:n]
+			off += n
+		}
+		batch := blocks[:k]
+		for i := range batch {
+			wg.Add(1)
+			go func(b *encodedBlock) {
+				defer wg.Done()
+				b.encode(r, sz.w)
+			}(&batch[i])
+		}
+		wg.Wait()
+
+		// write the batch in order.
+		for i := range batch {
+			b := &batch[i]
+			if b.err != nil {
+				sz.err = b.err
+				return total, sz.err
+			}
+			if sz.w.opts.Checksum != nil {
+				binary.LittleEndian.PutUint32(b.frame[4:8], sz.w.opts.Checksum(b.src))
+			}
+			sz.err = sz.w.writeFrame(b.frame, b.src, b.compressed)
+			if sz.err != nil {
+				return total, sz.err
+			}
+			total += int64(len(b.src))
+		}
+	}
+	return total, nil
+}
+
+// encodedBlock holds a block of data read by EncodeReaderAt and its encoding.
+type encodedBlock struct {
+	off        int64
+	src        []byte
+	frame      []byte
+	compressed bool
+	err        error
+}
+
+// encode reads b.src from r at b.off and encodes it as configured for w.  If
+// w has a custom checksum function the checksum is left zero, to be computed
+// by the caller.
+func (b *encodedBlock) encode(r io.ReaderAt, w *writer) {
+	b.err = readFullAt(r, b.src, b.off)
+	if b.err != nil {
+		return
+	}
+	var checksum uint32
+	if w.opts.Checksum == nil {
+		checksum = crc(b.src)
+	}
+	b.frame, b.compressed = encodeFrame(b.frame, b.src, checksum, &w.opts)
+}
+
+// DecodedLen returns the total length of the data decoded from the snappy
+// framed stream of size bytes read from r.  Only chunk headers and the length
+// preamble of compressed blocks are read, so DecodedLen is much cheaper than
+// decoding the stream but does not verify block data or checksums.  DecodedLen
+// returns an error if the stream is malformed or truncated.
+func DecodedLen(r io.ReaderAt, size int64) (int64, error) {
+	var hdr [4]byte
+	var buf [6]byte // stream identifier data or a compressed block preamble
+	var total int64
+	seenStreamID := false
+	for off := int64(0); off < size; {
+		if size-off < int64(len(hdr)) {
+			return total, io.ErrUnexpectedEOF
+		}
+		err := readFullAt(r, hdr[:], off)
+		if err != nil {
+			return total, err
+		}
+		typ := hdr[0]
+		length := int64(decodeLength(hdr[1:]))
+		body := off + int64(len(hdr))
+		if size-body < length {
+			return total, io.ErrUnexpectedEOF
+		}
+		off = body + length
+
+		if typ == blockStreamIdentifier {
+			if !bytes.Equal(hdr[:], streamID[:4]) {
+				return total, fmt.Errorf("invalid stream identifier length")
+			}
+			err := readFullAt(r, buf[:], body)
+			if err != nil {
+				return total, err
+			}
+			if !bytes.Equal(buf[:], streamID[4:]) {
+				return total, fmt.Errorf("invalid stream identifier block")
+			}
+			seenStreamID = true
+			continue
+		}
+		if !seenStreamID {
+			return total, errMissingStreamID
+		}
+
+		switch {
+		case typ == blockCompressed:
+			if length < minDataBlockSize {
+				return total, ErrEmptyBlock
+			}
+			// the decoded length is a uvarint of at most 5 bytes preceding
+			// the snappy block body.
+			pre := buf[:5]
+			if length-4 < int64(len(pre)) {
+				pre = pre[:length-4]
+			}
+			err := readFullAt(r, pre, body+4)
+			if err != nil {
+				return total, err
+			}
+			declen, err := snappy.DecodedLen(pre)
+			if err != nil {
+				return total, err
+			}
+			if declen > maxBlockSize {
+				return total, fmt.Errorf("%w: decoded length %d > %d", ErrBlockTooLarge, declen, maxBlockSize)
+			}
+			total += int64(declen)
+		case typ == blockUncompressed:
+			if length < minDataBlockSize {
+				return total, ErrEmptyBlock
+			}
+			if length-4 > maxBlockSize {
+				return total, fmt.Errorf("%w: decoded length %d > %d", ErrBlockTooLarge, length-4, maxBlockSize)
+			}
+			total += length - 4
+		case isSkippable(typ):
+			// skipped without reading
+		default:
+			return total, fmt.Errorf("unrecognized unskippable frame %#x", typ)
+		}
+	}
+	return total, nil
+}
+
+// readFullAt reads len(p) bytes from r at offset off.  A short read results in
+// io.ErrUnexpectedEOF.
+func readFullAt(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == nil || err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
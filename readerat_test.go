@@ -0,0 +1,152 @@
+package snappyframed
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// This test checks that EncodeReaderAt writes the same stream as ReadFrom.
+func TestWriterEncodeReaderAt(t *testing.T) {
+	for _, size := range []int{0, 100, MaxBlockSize, 7*MaxBlockSize + 1234} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i % 251)
+		}
+		copy(data[size/2:], randBytes(t, size/4))
+
+		for _, opts := range []*WriterOptions{nil, {StreamChecksum: true}} {
+			var serial bytes.Buffer
+			w := NewWriterOptions(&serial, opts)
+			_, err := w.ReadFrom(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("%d bytes: read from: %v", size, err)
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatalf("%d bytes: close: %v", size, err)
+			}
+
+			var parallel bytes.Buffer
+			w = NewWriterOptions(&parallel, opts)
+			n, err := w.EncodeReaderAt(bytes.NewReader(data), int64(size))
+			if err != nil {
+				t.Fatalf("%d bytes: encode: %v", size, err)
+			}
+			if n != int64(size) {
+				t.Fatalf("%d bytes: encoded %d bytes", size, n)
+			}
+			err = w.Close()
+			if err != nil {
+				t.Fatalf("%d bytes: close: %v", size, err)
+			}
+
+			if !bytes.Equal(parallel.Bytes(), serial.Bytes()) {
+				t.Fatalf("%d bytes: stream differs from serial encoding", size)
+			}
+		}
+	}
+}
+
+// This test checks that EncodeReaderAt never calls a custom checksum function
+// concurrently and writes the same stream as ReadFrom using it.
+func TestWriterEncodeReaderAt_checksum(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	data := bytes.Repeat(testDataJSON, 8*MaxBlockSize/len(testDataJSON)+1)[:8*MaxBlockSize]
+	var active, concurrent int32
+	opts := &WriterOptions{Checksum: func(p []byte) uint32 {
+		if atomic.AddInt32(&active, 1) > 1 {
+			atomic.StoreInt32(&concurrent, 1)
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&active, -1)
+		return crc32.ChecksumIEEE(p)
+	}}
+
+	var serial bytes.Buffer
+	w := NewWriterOptions(&serial, opts)
+	w.ReadFrom(bytes.NewReader(data))
+	w.Close()
+
+	var parallel bytes.Buffer
+	w = NewWriterOptions(&parallel, opts)
+	_, err := w.EncodeReaderAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	w.Close()
+	if atomic.LoadInt32(&concurrent) != 0 {
+		t.Fatalf("checksum called concurrently")
+	}
+	if !bytes.Equal(parallel.Bytes(), serial.Bytes()) {
+		t.Fatalf("stream differs from serial encoding")
+	}
+}
+
+// This test checks that EncodeReaderAt reports a short read and does not
+// write blocks past it.
+func TestWriterEncodeReaderAt_short(t *testing.T) {
+	data := make([]byte, 2*MaxBlockSize+10)
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	n, err := w.EncodeReaderAt(bytes.NewReader(data), 3*MaxBlockSize)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("encode: %v", err)
+	}
+	if n != 2*MaxBlockSize {
+		t.Fatalf("encoded %d bytes", n)
+	}
+}
+
+func TestDecodedLen(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		p    []byte
+	}{
+		{"empty", nil},
+		{"manpage", testDataMan},
+		{"json", testDataJSON},
+		{"random", randBytes(t, 2*MaxBlockSize+17)},
+		{"constant", make([]byte, 4*MaxBlockSize+1)},
+	} {
+		enc, err := encodeStreamBytes(test.p, false)
+		if err != nil {
+			t.Fatalf("%s: encode: %v", test.name, err)
+		}
+		n, err := DecodedLen(bytes.NewReader(enc), int64(len(enc)))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if n != int64(len(test.p)) {
+			t.Errorf("%s: decoded length %d (!= %d)", test.name, n, len(test.p))
+		}
+
+		if len(enc) > len(streamID) {
+			_, err = DecodedLen(bytes.NewReader(enc), int64(len(enc)-1))
+			if err != io.ErrUnexpectedEOF {
+				t.Errorf("%s: truncated: %v", test.name, err)
+			}
+		}
+	}
+
+	stream := bytes.Join([][]byte{
+		streamID,
+		opaqueChunk(0xfe, 10),
+		compressedChunk(t, []byte("padded")),
+		opaqueChunk(0x03, 10),
+	}, nil)
+	_, err := DecodedLen(bytes.NewReader(stream), int64(len(stream)))
+	if err == nil || !strings.Contains(err.Error(), "unskippable") {
+		t.Errorf("unskippable chunk: %v", err)
+	}
+	_, err = DecodedLen(bytes.NewReader(stream[len(streamID):]), int64(len(stream)-len(streamID)))
+	if err != errMissingStreamID {
+		t.Errorf("missing stream identifier: %v", err)
+	}
+}
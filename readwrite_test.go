@@ -1,10 +1,13 @@
 package snappyframed
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/rand"
 	"io"
 	"io/ioutil"
+	"runtime"
+	"strings"
 	"sync"
 	"testing"
 )
@@ -168,8 +171,8 @@ func benchmarkWriterBytes(b *testing.B, p []byte) {
 	w := NewWriter(ioutil.Discard)
 	wcloser := &nopWriteCloser{w}
 	enc := func() io.WriteCloser {
-		// wrap the normal writer so that it has a noop Close method.  writer
-		// does not implement ReaderFrom so this does not impact performance.
+		// wrap the normal writer so that it has a noop Close method.  the
+		// wrapper hides the Writer's ReaderFrom implementation.
 		w.Reset(ioutil.Discard)
 		return wcloser
 	}
@@ -281,6 +284,59 @@ func BenchmarkReaderConstantPool(b *testing.B) {
 	encodeAndBenchmarkReaderPool(b, make([]byte, TestFileSize))
 }
 
+// BenchmarkReaderFragmented tests decoding of streams in which every block
+// holds a single byte, as produced by chatty protocols which flush each
+// write.  The cost is dominated by per-frame overhead.
+func BenchmarkReaderFragmented(b *testing.B) {
+	p := testDataJSON[:4096]
+	enc, err := encodeFragmented(p)
+	if err != nil {
+		b.Fatalf("pre-benchmark compression: %v", err)
+	}
+	r := NewReader(nil)
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	mallocs := mem.Mallocs
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(bytes.NewReader(enc))
+		n, err := io.Copy(ioutil.Discard, r)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != int64(len(p)) {
+			b.Fatalf("read wrong amount %d != %d", n, len(p))
+		}
+	}
+	b.StopTimer()
+	runtime.ReadMemStats(&mem)
+	b.ReportMetric(float64(mem.Mallocs-mallocs)/float64(b.N*len(p)), "allocs/frame")
+}
+
+// encodeFragmented encodes p as a snappy framed stream, flushing after each
+// byte so that every data block holds a single byte.
+func encodeFragmented(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i := range p {
+		_, err := w.Write(p[i : i+1])
+		if err != nil {
+			return nil, err
+		}
+		err = w.Flush()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // encodeAndBenchmarkReader is a helper that benchmarks the package
 // reader's performance given p encoded as a snappy framed stream.
 //
@@ -363,9 +419,10 @@ func encodeAndBenchmarkReaderPool(b *testing.B, p []byte) {
 
 // benchmarkDecode runs a benchmark that repeatedly decoded snappy
 // framed bytes enc.  The length of the decoded result in each iteration must
-// equal size.
+// equal size.  Throughput is reported relative to the decoded size so that
+// results are comparable with the Writer benchmarks.
 func benchmarkDecode(b *testing.B, dec func(io.Reader) io.ReadCloser, size int64, enc []byte) {
-	b.SetBytes(int64(len(enc))) // BUG this is probably wrong
+	b.SetBytes(size)
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		r := dec(bytes.NewReader(enc))
@@ -420,7 +477,7 @@ func encodeStream(r io.Reader, buffer bool) ([]byte, error) {
 // randBytes reads size bytes from the computer's cryptographic random source.
 // the resulting bytes have approximately maximal entropy and are effectively
 // uncompressible with any algorithm.
-func randBytes(b *testing.B, size int) []byte {
+func randBytes(b testing.TB, size int) []byte {
 	randp := make([]byte, size)
 	_, err := io.ReadFull(rand.Reader, randp)
 	if err != nil {
@@ -472,3 +529,280 @@ func (r *poolReader) Close() error {
 	r.Reader = nil
 	return nil
 }
+
+// TestWriterReadFrom checks that io.Copy from a Reader into a Writer produces a
+// valid stream regardless of the Writer's initial buffered state.
+func TestWriterReadFrom(t *testing.T) {
+	p := bytes.Repeat(testDataJSON, 10)
+	enc, err := encodeStreamBytes(p, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+
+	for _, prefix := range []string{"", "buffered prefix"} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		io.WriteString(w, prefix)
+		// hide the Reader's WriterTo implementation so that ReadFrom is used.
+		n, err := io.Copy(w, struct{ io.Reader }{NewReader(bytes.NewReader(enc))})
+		if err != nil {
+			t.Fatalf("copy: %v", err)
+		}
+		if n != int64(len(p)) {
+			t.Fatalf("copied %d bytes (!= %d)", n, len(p))
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		dec, err := ioutil.ReadAll(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(dec, append([]byte(prefix), p...)) {
+			t.Fatalf("unequal decoded content")
+		}
+	}
+}
+
+func BenchmarkWriterReadFrom(b *testing.B) {
+	benchmarkWriterReadFrom(b, func() io.Writer {
+		return NewWriter(ioutil.Discard)
+	})
+}
+
+// BenchmarkWriterReadFromBuffered copies through the Writer's internal buffer
+// as was done before the inner writer implemented ReaderFrom.
+func BenchmarkWriterReadFromBuffered(b *testing.B) {
+	benchmarkWriterReadFrom(b, func() io.Writer {
+		sz := newWriter(ioutil.Discard)
+		return &Writer{
+			w:  sz,
+			bw: bufio.NewWriterSize(struct{ io.Writer }{sz}, maxBlockSize),
+		}
+	})
+}
+
+// benchmarkWriterReadFrom benchmarks copying from a Reader directly into a
+// writer returned by enc.
+func benchmarkWriterReadFrom(b *testing.B, enc func() io.Writer) {
+	p := bytes.Repeat(testDataJSON, 100)
+	encp, err := encodeStreamBytes(p, true)
+	if err != nil {
+		b.Fatalf("pre-benchmark compression: %v", err)
+	}
+	r := NewReader(nil)
+	w := enc()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Reset(bytes.NewReader(encp))
+		w.(*Writer).Reset(ioutil.Discard)
+		// hide the Reader's WriterTo implementation so that ReadFrom is used.
+		_, err := io.Copy(w, struct{ io.Reader }{r})
+		if err != nil {
+			b.Fatal(err)
+		}
+		err = w.(*Writer).Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+}
+
+// This test checks that stream checksums written by a Writer are verified by
+// a Reader and detect modifications which block checksums do not.
+func TestStreamChecksum(t *testing.T) {
+	encode := func(blocks ...[]byte) []byte {
+		var buf bytes.Buffer
+		w := NewWriterOptions(&buf, &WriterOptions{StreamChecksum: true})
+		for _, p := range blocks {
+			_, err := w.Write(p)
+			if err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			err = w.Flush()
+			if err != nil {
+				t.Fatalf("flush: %v", err)
+			}
+		}
+		err := w.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		return buf.Bytes()
+	}
+	decode := func(enc []byte) ([]byte, error) {
+		r := NewReader(bytes.NewReader(enc))
+		r.VerifyStreamChecksum(true)
+		return ioutil.ReadAll(r)
+	}
+
+	blocks := [][]byte{[]byte("first "), []byte("second "), []byte("third")}
+	enc := encode(blocks...)
+	p, err := decode(enc)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "first second third" {
+		t.Fatalf("read: unexpected content %q", p)
+	}
+
+	// conformant readers ignore the stream checksum
+	p, err = ioutil.ReadAll(NewReader(bytes.NewReader(enc)))
+	if err != nil {
+		t.Fatalf("read unverified: %v", err)
+	}
+	if string(p) != "first second third" {
+		t.Fatalf("read unverified: unexpected content %q", p)
+	}
+
+	// an empty stream has a checksum
+	_, err = decode(encode())
+	if err != nil {
+		t.Fatalf("read empty: %v", err)
+	}
+
+	// reordering blocks is not detected by block checksums.
+	chunks := splitChunks(t, enc)
+	chunks[1], chunks[2] = chunks[2], chunks[1]
+	reordered := bytes.Join(chunks, nil)
+	_, err = ioutil.ReadAll(NewReader(bytes.NewReader(reordered)))
+	if err != nil {
+		t.Fatalf("read reordered unverified: %v", err)
+	}
+	_, err = decode(reordered)
+	if err != ErrStreamChecksum {
+		t.Fatalf("read reordered: %v", err)
+	}
+
+	// a stream truncated before its checksum
+	_, err = decode(enc[:len(enc)-8])
+	if err != errMissingStreamChecksum {
+		t.Fatalf("read truncated: %v", err)
+	}
+}
+
+// splitChunks splits a snappy framed stream into its individual chunks.
+func splitChunks(t *testing.T, stream []byte) [][]byte {
+	var chunks [][]byte
+	for len(stream) > 0 {
+		if len(stream) < 4 {
+			t.Fatalf("truncated chunk header")
+		}
+		n := 4 + int(decodeLength(stream[1:4]))
+		if len(stream) < n {
+			t.Fatalf("truncated chunk")
+		}
+		chunks = append(chunks, stream[:n])
+		stream = stream[n:]
+	}
+	return chunks
+}
+
+// This test checks that a Reader or Writer with no underlying reader or writer
+// returns errors instead of panicking.
+func TestNilTarget(t *testing.T) {
+	w := NewWriter(nil)
+	_, err := w.Write([]byte("hello"))
+	if err != ErrNilTarget {
+		t.Errorf("write: %v", err)
+	}
+	_, err = w.ReadFrom(bytes.NewReader([]byte("hello")))
+	if err != ErrNilTarget {
+		t.Errorf("read from: %v", err)
+	}
+	err = w.Flush()
+	if err != ErrNilTarget {
+		t.Errorf("flush: %v", err)
+	}
+	err = w.FlushFrame()
+	if err != ErrNilTarget {
+		t.Errorf("flush frame: %v", err)
+	}
+	err = w.Close()
+	if err != ErrNilTarget {
+		t.Errorf("close: %v", err)
+	}
+
+	r := NewReader(nil)
+	_, err = r.Read(make([]byte, 10))
+	if err != ErrNilTarget {
+		t.Errorf("read: %v", err)
+	}
+	r = NewReader(nil)
+	_, err = r.WriteTo(ioutil.Discard)
+	if err != ErrNilTarget {
+		t.Errorf("write to: %v", err)
+	}
+	r = NewReader(nil)
+	_, err = r.ReadByte()
+	if err != ErrNilTarget {
+		t.Errorf("read byte: %v", err)
+	}
+
+	// the reader and writer are usable after Reset
+	var buf bytes.Buffer
+	w.Reset(&buf)
+	_, err = w.Write([]byte("hello"))
+	if err != nil {
+		t.Fatalf("write after reset: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close after reset: %v", err)
+	}
+	r.Reset(&buf)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read after reset: %v", err)
+	}
+	if string(p) != "hello" {
+		t.Fatalf("read after reset: unexpected content %q", p)
+	}
+}
+
+// This test checks that streams written with a custom checksum function are
+// read by a Reader using the same function and rejected by one using another.
+func TestChecksumFunc(t *testing.T) {
+	sum := func(p []byte) uint32 {
+		var c uint32
+		for _, b := range p {
+			c = c*31 + uint32(b)
+		}
+		return c
+	}
+	data := bytes.Repeat([]byte("custom checksum "), 1000)
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{Checksum: sum})
+	_, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	r := NewReaderOptions(bytes.NewReader(buf.Bytes()), &ReaderOptions{Checksum: sum})
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("read: decoded data differs")
+	}
+
+	_, err = ioutil.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("read with default checksum: %v", err)
+	}
+
+	other := func(p []byte) uint32 { return sum(p) + 1 }
+	r = NewReaderOptions(bytes.NewReader(buf.Bytes()), &ReaderOptions{Checksum: other})
+	_, err = ioutil.ReadAll(r)
+	if err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Fatalf("read with mismatched checksum: %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+package snappyframed
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ScanFrames is a split function for a bufio.Scanner that returns the data
+// decoded from each data block in a snappy framed stream.  Each block's
+// checksum is verified before its data is returned.  Stream identifiers,
+// padding, and reserved skippable chunks are consumed without producing a
+// token, as are blocks containing no data.  A reserved unskippable chunk is
+// an error.
+//
+// Because a split function retains no state ScanFrames cannot require that
+// the stream begin with a stream identifier.  A Scanner holds an entire chunk
+// in its buffer, so the Scanner's maximum buffer size, set with
+// bufio.Scanner.Buffer, must be at least MaxEncodedLen(MaxBlockSize) bytes,
+// and larger if the stream contains long skippable chunks.
+func ScanFrames(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if len(data) < 4 {
+		if atEOF && len(data) > 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	typ, length := DecodeHeader(data)
+	if len(data) < 4+length {
+		if atEOF {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		return 0, nil, nil
+	}
+	chunk := data[:4+length]
+
+	switch {
+	case typ == blockCompressed || typ == blockUncompressed:
+		dec, err := DecodeBlock(nil, chunk)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(dec) == 0 {
+			dec = nil
+		}
+		return len(chunk), dec, nil
+	case typ == blockStreamIdentifier:
+		if !bytes.Equal(chunk, streamID) {
+			return 0, nil, fmt.Errorf("invalid stream identifier block")
+		}
+		return len(chunk), nil, nil
+	case isSkippable(typ):
+		return len(chunk), nil, nil
+	default:
+		return 0, nil, fmt.Errorf("unrecognized unskippable frame %#x", typ)
+	}
+}
@@ -0,0 +1,76 @@
+package snappyframed
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"testing"
+)
+
+// This test checks that a bufio.Scanner using ScanFrames returns the data of
+// each block in a stream.
+func TestScanFrames(t *testing.T) {
+	blocks := [][]byte{
+		[]byte("first block"),
+		bytes.Repeat([]byte("a full block "), MaxBlockSize/13+1)[:MaxBlockSize],
+		randBytes(t, 1000),
+		[]byte("last block"),
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for i, p := range blocks {
+		w.Write(p)
+		w.Flush()
+		if i == 1 {
+			w.PadTo(4096)
+		}
+	}
+	w.Close()
+	stream := buf.Bytes()
+
+	// short reads force ScanFrames to request more data.
+	s := bufio.NewScanner(&slowReader{bytes.NewReader(stream)})
+	s.Buffer(nil, MaxEncodedLen(MaxBlockSize))
+	s.Split(ScanFrames)
+	var i int
+	for ; s.Scan(); i++ {
+		if i >= len(blocks) {
+			t.Fatalf("unexpected token %d", i)
+		}
+		if !bytes.Equal(s.Bytes(), blocks[i]) {
+			t.Fatalf("token %d differs", i)
+		}
+	}
+	if s.Err() != nil {
+		t.Fatalf("scan: %v", s.Err())
+	}
+	if i != len(blocks) {
+		t.Fatalf("scanned %d tokens (!= %d)", i, len(blocks))
+	}
+
+	// a corrupt checksum and a truncated stream are errors.
+	corrupt := append([]byte(nil), stream...)
+	corrupt[len(streamID)+4] ^= 0xff
+	for _, p := range [][]byte{corrupt, stream[:len(stream)-1]} {
+		s = bufio.NewScanner(bytes.NewReader(p))
+		s.Buffer(nil, MaxEncodedLen(MaxBlockSize))
+		s.Split(ScanFrames)
+		for s.Scan() {
+		}
+		if s.Err() == nil {
+			t.Fatalf("scanned %d bytes of invalid stream without error", len(p))
+		}
+	}
+}
+
+// slowReader returns at most 1000 bytes from each call to Read.
+type slowReader struct {
+	r io.Reader
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(p) > 1000 {
+		p = p[:1000]
+	}
+	return r.r.Read(p)
+}
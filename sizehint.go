@@ -0,0 +1,78 @@
+package snappyframed
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// sizeHintLength is the length of the data in a size hint chunk.
+const sizeHintLength = 8
+
+// SetDecodedSizeHint causes sz to write n, the total number of bytes which
+// will be written to sz, in a reserved skippable chunk (type 0x82) following
+// the stream identifier, where it may be read with Reader.DecodedSizeHint.
+// Conformant readers ignore the chunk.  The hint is advisory and sz does not
+// verify that n bytes are written.  SetDecodedSizeHint returns an error if n
+// is negative or if the stream identifier has already been written.  Reset
+// discards the hint.
+func (sz *Writer) SetDecodedSizeHint(n int64) error {
+	if sz.err != nil {
+		return sz.err
+	}
+	if n < 0 {
+		return fmt.Errorf("invalid size hint %d", n)
+	}
+	if sz.w.sentStreamID {
+		return fmt.Errorf("size hint follows stream identifier")
+	}
+	sz.w.sizeHint = n
+	sz.w.hasSizeHint = true
+	return nil
+}
+
+// writeSizeHint writes a chunk containing the decoded size hint.
+func (sz *writer) writeSizeHint() error {
+	chunk := make([]byte, 4+sizeHintLength)
+	chunk[0] = blockSizeHint
+	chunk[1] = sizeHintLength
+	binary.LittleEndian.PutUint64(chunk[4:], uint64(sz.sizeHint))
+	err := sz.writeFull(chunk)
+	if err != nil {
+		return fmt.Errorf("writing size hint: %w", err)
+	}
+	sz.stats.Frames++
+	sz.stats.BytesOut += int64(len(chunk))
+	sz.trace(blockSizeHint, len(chunk), 0)
+	return nil
+}
+
+// DecodedSizeHint returns the decoded length of the stream written with
+// Writer.SetDecodedSizeHint, reading the beginning of the stream if necessary
+// as Header does.  The hint is advisory and may not match the length of the
+// stream.  If the stream has no hint, or its beginning cannot be read,
+// DecodedSizeHint returns false.
+func (sz *Reader) DecodedSizeHint() (int64, bool) {
+	err := sz.readPreamble()
+	if err != nil {
+		return 0, false
+	}
+	return sz.sizeHint, sz.hasSizeHint
+}
+
+// readSizeHint reads a size hint chunk.  Malformed hints are discarded.
+func (sz *Reader) readSizeHint() error {
+	if decodeLength(sz.hdr[1:]) != sizeHintLength {
+		return sz.discardBlock()
+	}
+	buf := sz.src[:sizeHintLength]
+	err := sz.inspectBlock(buf)
+	if err != nil {
+		return err
+	}
+	n := int64(binary.LittleEndian.Uint64(buf))
+	if n >= 0 {
+		sz.sizeHint = n
+		sz.hasSizeHint = true
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// This test checks that a size hint written by a Writer is read by a Reader
+// and ignored when decoding.
+func TestDecodedSizeHint(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{Header: &Header{Name: "hinted"}})
+	err := w.SetDecodedSizeHint(int64(len(data)))
+	if err != nil {
+		t.Fatalf("set hint: %v", err)
+	}
+	w.Write(data)
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	n, ok := r.DecodedSizeHint()
+	if !ok || n != int64(len(data)) {
+		t.Fatalf("hint %d %t (!= %d)", n, ok, len(data))
+	}
+	h, err := r.Header()
+	if err != nil || h.Name != "hinted" {
+		t.Fatalf("header: %+v %v", h, err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+
+	// the hint cannot follow the stream identifier.
+	err = w.SetDecodedSizeHint(1)
+	if err == nil {
+		t.Fatalf("hint set after close")
+	}
+	w.Reset(ioutil.Discard)
+	w.Write(data)
+	w.Flush()
+	if w.SetDecodedSizeHint(1) == nil {
+		t.Fatalf("hint set after data")
+	}
+	if w.SetDecodedSizeHint(-1) == nil {
+		t.Fatalf("negative hint accepted")
+	}
+
+	enc, err := encodeStreamBytes(data, false)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	r.Reset(bytes.NewReader(enc))
+	n, ok = r.DecodedSizeHint()
+	if ok {
+		t.Fatalf("hint %d in stream without a hint", n)
+	}
+	p, err = ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(p, data) {
+		t.Fatalf("read without hint: %v", err)
+	}
+}
+
+// This test checks that a size hint chunk is read subject to the skip limit
+// and written to the discard sink like other reserved skippable chunks.
+func TestDecodedSizeHint_discardSink(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := w.SetDecodedSizeHint(4)
+	if err != nil {
+		t.Fatalf("set hint: %v", err)
+	}
+	w.Write([]byte("data"))
+	w.Close()
+	var payload []byte
+	for _, chunk := range splitChunks(t, buf.Bytes()) {
+		if chunk[0] == blockSizeHint {
+			payload = chunk[4:]
+		}
+	}
+
+	var sink bytes.Buffer
+	var paced int
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetDiscardSink(&sink, 1<<20)
+	r.SetSkipLimit(3, func(n int) error {
+		paced += n
+		return nil
+	})
+	n, ok := r.DecodedSizeHint()
+	if !ok || n != 4 {
+		t.Fatalf("hint: %d %t", n, ok)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil || string(p) != "data" {
+		t.Fatalf("read: %q %v", p, err)
+	}
+	if !bytes.Equal(sink.Bytes(), payload) || paced != len(payload) {
+		t.Fatalf("sink: %x (!= %x), paced %d bytes", sink.Bytes(), payload, paced)
+	}
+}
@@ -8,6 +8,7 @@ https://snappy.googlecode.com/svn/trunk/framing_format.txt
 package snappyframed
 
 import (
+	"fmt"
 	"hash/crc32"
 
 	"github.com/golang/snappy"
@@ -23,10 +24,50 @@ const MediaType = "application/x-snappy-framed"
 // requests containing a snappy framed entity body.
 const ContentEncoding = "x-snappy-framed"
 
+// ErrNilTarget is returned by a Reader or Writer which has no underlying
+// io.Reader or io.Writer, such as one created with NewReader(nil) or
+// NewWriter(nil) and not yet Reset.
+var ErrNilTarget = fmt.Errorf("nil underlying reader or writer")
+
 // maxBlockSize is the maximum number of decoded bytes allowed to be
 // represented in a snappy framed block (sections 4.2 and 4.3).
 const maxBlockSize = 65536
 
+// MaxBlockSize is the maximum number of decoded bytes contained in a single
+// snappy framed data block.
+const MaxBlockSize = maxBlockSize
+
+// blockHeaderSize is the size of a data block's chunk header, including the
+// block checksum.
+const blockHeaderSize = 8
+
+// minDataBlockSize is the minimum length of a data block: a checksum and at
+// least one byte of block data.  A compressed block containing no data
+// consists of a single byte, its encoded length.
+const minDataBlockSize = 5
+
+// MaxEncodedLen returns the maximum length of a snappy framed stream encoding
+// srcLen bytes in blocks of MaxBlockSize bytes, as done by a Writer which is
+// not flushed before it is closed.  The bound accounts for the stream
+// identifier, block headers, and the worst case expansion of snappy encoded
+// data.  Padding is not included in the bound.  MaxEncodedLen returns -1 if
+// srcLen is negative or the bound is too large to represent as an int.
+func MaxEncodedLen(srcLen int) int {
+	if srcLen < 0 {
+		return -1
+	}
+	n := uint64(len(streamID))
+	full := uint64(srcLen / maxBlockSize)
+	n += full * uint64(blockHeaderSize+snappy.MaxEncodedLen(maxBlockSize))
+	if rem := srcLen % maxBlockSize; rem > 0 {
+		n += uint64(blockHeaderSize + snappy.MaxEncodedLen(rem))
+	}
+	if n > uint64(int(^uint(0)>>1)) {
+		return -1
+	}
+	return int(n)
+}
+
 // maxEncodedBlockSize is the maximum number of encoded bytes in a framed
 // block.
 var maxEncodedBlockSize = uint32(snappy.MaxEncodedLen(maxBlockSize))
@@ -39,6 +80,77 @@ const (
 	blockStreamIdentifier = 0xff
 )
 
+// Chunk types defined by the snappy framed format specification, for use
+// with EncodeHeader and DecodeHeader.
+const (
+	ChunkCompressed       byte = blockCompressed
+	ChunkUncompressed     byte = blockUncompressed
+	ChunkPadding          byte = blockPadding
+	ChunkStreamIdentifier byte = blockStreamIdentifier
+)
+
+// ChunkHeaderSize is the size of the header of a chunk other than a data
+// chunk: the chunk type followed by the 3-byte little-endian length of the
+// chunk data.  Data chunks (ChunkCompressed and ChunkUncompressed) have
+// headers of DataChunkHeaderSize bytes which include the checksum of the
+// decoded data.
+const ChunkHeaderSize = 4
+
+// DataChunkHeaderSize is the size of the header of a data chunk, including
+// the masked CRC-32C checksum of the decoded data.
+const DataChunkHeaderSize = blockHeaderSize
+
+// EncodeHeader writes the header of a chunk of type typ to dst.  For data
+// chunks (ChunkCompressed and ChunkUncompressed) encoded is the block data
+// following the header and decoded is the data it encodes, whose masked
+// CRC-32C checksum is stored in the header; the header occupies
+// DataChunkHeaderSize bytes.  For other chunk types encoded is the chunk data,
+// decoded is ignored, and the header occupies ChunkHeaderSize bytes.
+//
+// EncodeHeader panics if dst is too short or if the chunk length cannot be
+// represented in a chunk header.
+func EncodeHeader(dst []byte, typ byte, encoded, decoded []byte) {
+	if typ != blockCompressed && typ != blockUncompressed {
+		if len(encoded) > maxChunkLength {
+			panic(fmt.Sprintf("chunk too large %d > %d", len(encoded), maxChunkLength))
+		}
+		length := len(encoded)
+		dst[0] = typ
+		dst[1] = byte(length)
+		dst[2] = byte(length >> 8)
+		dst[3] = byte(length >> 16)
+		return
+	}
+	if len(encoded)+4 > maxChunkLength {
+		panic(fmt.Sprintf("chunk too large %d > %d", len(encoded)+4, maxChunkLength))
+	}
+	writeHeader(dst, typ, encoded, crc(decoded))
+}
+
+// DecodeHeader returns the type of the chunk whose header begins hdr and the
+// length of the chunk data following the 4-byte type and length fields.  For
+// data chunks the length includes the 4-byte checksum.  DecodeHeader panics if
+// hdr is shorter than ChunkHeaderSize.
+func DecodeHeader(hdr []byte) (typ byte, length int) {
+	return hdr[0], int(decodeLength(hdr[1:4]))
+}
+
+// Reserved skippable chunk types with a meaning defined by this package.
+// Conformant readers ignore these chunks.
+const (
+	// blockStreamChecksum contains the 4-byte little-endian masked CRC-32C
+	// checksum of all data in the stream preceding it.
+	blockStreamChecksum = 0x80
+
+	// blockStreamHeader contains an encoded Header describing the stream.  It
+	// immediately follows the stream identifier.
+	blockStreamHeader = 0x81
+
+	// blockSizeHint contains the 8-byte little-endian decoded length of the
+	// stream.  It follows the stream identifier and any Header.
+	blockSizeHint = 0x82
+)
+
 // streamID is the stream identifier block that begins a valid snappy framed
 // stream.
 var streamID = []byte{0xff, 0x06, 0x00, 0x00, 0x73, 0x4e, 0x61, 0x50, 0x70, 0x59}
@@ -48,6 +160,24 @@ func maskChecksum(c uint32) uint32 {
 	return ((c >> 15) | (c << 17)) + 0xa282ead8
 }
 
+// crc returns the masked CRC-32C checksum of p stored in data chunks.
+func crc(p []byte) uint32 {
+	return maskChecksum(crc32.Checksum(p, crcTable))
+}
+
+// MaskedChecksum returns the masked CRC-32C (Castagnoli) checksum of p, the
+// value stored in the header of a data chunk whose decoded data is p.
+func MaskedChecksum(p []byte) uint32 {
+	return crc(p)
+}
+
+// UnmaskChecksum returns the CRC-32C checksum from which the masked checksum
+// was computed.  The result may be compared with the value computed by
+// hash/crc32 using crc32.MakeTable(crc32.Castagnoli).
+func UnmaskChecksum(masked uint32) uint32 {
+	return unmaskChecksum(masked)
+}
+
 var crcTable *crc32.Table
 
 func init() {
@@ -0,0 +1,169 @@
+package snappyframed
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// This test checks that MaxEncodedLen bounds the length of encoded random
+// data spanning various numbers of blocks.
+func TestMaxEncodedLen(t *testing.T) {
+	for _, n := range []int{
+		0,
+		1,
+		1000,
+		MaxBlockSize - 1,
+		MaxBlockSize,
+		MaxBlockSize + 1,
+		3*MaxBlockSize + 100,
+		10 * MaxBlockSize,
+	} {
+		p := make([]byte, n)
+		_, err := rand.Read(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		_, err = w.Write(p)
+		if err != nil {
+			t.Fatalf("write %d: %v", n, err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("close %d: %v", n, err)
+		}
+		if max := MaxEncodedLen(n); buf.Len() > max {
+			t.Errorf("encoded length %d exceeds bound %d for %d bytes", buf.Len(), max, n)
+		}
+	}
+
+	if MaxEncodedLen(-1) != -1 {
+		t.Errorf("negative length: %d", MaxEncodedLen(-1))
+	}
+}
+
+// Reader and Writer can be reused by frameworks written for the decoders and
+// encoders in the compress packages.
+var (
+	_ interface{ Reset(io.Reader) error } = (*Reader)(nil)
+	_ interface{ Reset(io.Writer) }       = (*Writer)(nil)
+	_ interface{ Reset(io.Reader) error } = (*gzip.Reader)(nil)
+	_ interface{ Reset(io.Writer) }       = (*gzip.Writer)(nil)
+)
+
+// This test checks that headers written by EncodeHeader for each chunk type
+// are parsed by DecodeHeader and accepted by a Reader.
+func TestEncodeHeader(t *testing.T) {
+	data := []byte("header data")
+	enc := snappy.Encode(nil, data)
+	for _, test := range []struct {
+		typ     byte
+		encoded []byte
+		size    int
+		length  int
+	}{
+		{ChunkCompressed, enc, DataChunkHeaderSize, len(enc) + 4},
+		{ChunkUncompressed, data, DataChunkHeaderSize, len(data) + 4},
+		{ChunkPadding, make([]byte, 7), ChunkHeaderSize, 7},
+		{ChunkStreamIdentifier, streamID[4:], ChunkHeaderSize, 6},
+		{ChunkCompressed, make([]byte, maxChunkLength-4), DataChunkHeaderSize, maxChunkLength},
+		{ChunkPadding, make([]byte, maxChunkLength), ChunkHeaderSize, maxChunkLength},
+	} {
+		hdr := make([]byte, test.size)
+		EncodeHeader(hdr, test.typ, test.encoded, data)
+		typ, length := DecodeHeader(hdr)
+		if typ != test.typ {
+			t.Errorf("type %#x: decoded type %#x", test.typ, typ)
+		}
+		if length != test.length {
+			t.Errorf("type %#x: decoded length %d (!= %d)", test.typ, length, test.length)
+		}
+	}
+
+	// assemble a stream from hand-built chunks.
+	var stream []byte
+	for _, chunk := range []struct {
+		typ  byte
+		data []byte
+	}{
+		{ChunkStreamIdentifier, streamID[4:]},
+		{ChunkCompressed, enc},
+		{ChunkPadding, make([]byte, 3)},
+		{ChunkUncompressed, data},
+	} {
+		hdr := make([]byte, DataChunkHeaderSize)
+		EncodeHeader(hdr, chunk.typ, chunk.data, data)
+		if chunk.typ != ChunkCompressed && chunk.typ != ChunkUncompressed {
+			hdr = hdr[:ChunkHeaderSize]
+		}
+		stream = append(stream, hdr...)
+		stream = append(stream, chunk.data...)
+	}
+	p, err := ioutil.ReadAll(NewReader(bytes.NewReader(stream)))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != string(data)+string(data) {
+		t.Fatalf("decoded: %q", p)
+	}
+}
+
+// This test checks that EncodeHeader panics when a chunk length cannot be
+// represented.
+func TestEncodeHeader_tooLarge(t *testing.T) {
+	for _, test := range []struct {
+		typ byte
+		n   int
+	}{
+		{ChunkCompressed, maxChunkLength - 3},
+		{ChunkPadding, maxChunkLength + 1},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("type %#x: %d bytes encoded without panic", test.typ, test.n)
+				}
+			}()
+			EncodeHeader(make([]byte, DataChunkHeaderSize), test.typ, make([]byte, test.n), nil)
+		}()
+	}
+}
+
+// This test pins the exported checksum functions to known values.
+func TestMaskedChecksum(t *testing.T) {
+	table := crc32.MakeTable(crc32.Castagnoli)
+	for _, test := range []struct {
+		p      []byte
+		masked uint32
+	}{
+		{nil, 0xa282ead8},
+		{[]byte{}, 0xa282ead8},
+		{[]byte("123456789"), 0xc78ab0e5},
+	} {
+		masked := MaskedChecksum(test.p)
+		if masked != test.masked {
+			t.Errorf("%q: masked checksum %#x (!= %#x)", test.p, masked, test.masked)
+		}
+		if c := UnmaskChecksum(masked); c != crc32.Checksum(test.p, table) {
+			t.Errorf("%q: unmasked checksum %#x", test.p, c)
+		}
+	}
+
+	// the checksum of a chunk written by EncodeBlock.
+	frame, err := EncodeBlock(nil, []byte("checksum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := uint32(frame[4]) | uint32(frame[5])<<8 | uint32(frame[6])<<16 | uint32(frame[7])<<24
+	if stored != MaskedChecksum([]byte("checksum")) {
+		t.Errorf("stored checksum %#x", stored)
+	}
+}
@@ -0,0 +1,60 @@
+package snappyframed
+
+import (
+	"io"
+	"sync"
+)
+
+// SyncWriter wraps a Writer so that its methods may be called concurrently
+// from multiple goroutines, for example to Flush a Writer periodically while
+// another goroutine writes to it.  Calls are serialized, so a Flush waits for
+// a concurrent Write to complete and data written by a single call to Write
+// is never split between goroutines.  A Writer used by only one goroutine
+// does not need a SyncWriter.
+//
+// The wrapped Writer must not be used directly while the SyncWriter is in use.
+type SyncWriter struct {
+	mu sync.Mutex
+	sz *Writer
+}
+
+// NewSyncWriter returns a SyncWriter which serializes calls to sz.
+func NewSyncWriter(sz *Writer) *SyncWriter {
+	return &SyncWriter{sz: sz}
+}
+
+// Write calls Write on the wrapped Writer.
+func (w *SyncWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sz.Write(p)
+}
+
+// ReadFrom calls ReadFrom on the wrapped Writer.  Other calls block until r
+// has been read completely.
+func (w *SyncWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sz.ReadFrom(r)
+}
+
+// Flush calls Flush on the wrapped Writer.
+func (w *SyncWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sz.Flush()
+}
+
+// FlushFrame calls FlushFrame on the wrapped Writer.
+func (w *SyncWriter) FlushFrame() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sz.FlushFrame()
+}
+
+// Close calls Close on the wrapped Writer.
+func (w *SyncWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sz.Close()
+}
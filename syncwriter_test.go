@@ -0,0 +1,58 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+// This test is meaningful when run with the race detector.  It checks that
+// concurrent calls to Write and Flush produce a valid stream.
+func TestSyncWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewSyncWriter(NewWriter(&buf))
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			err := w.Flush()
+			if err != nil {
+				t.Errorf("flush: %v", err)
+				return
+			}
+		}
+	}()
+
+	var expect bytes.Buffer
+	for i := 0; i < 1000; i++ {
+		line := testDataJSON[:i%len(testDataJSON)+1]
+		expect.Write(line)
+		_, err := w.Write(line)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, expect.Bytes()) {
+		t.Fatalf("decoded data differs")
+	}
+}
@@ -0,0 +1,136 @@
+#!/usr/bin/env python3
+"""Generate the golden snappy framed streams used by golden_test.go.
+
+The streams are assembled chunk by chunk from the framing format description
+so that they can contain padding and reserved skippable chunks, which encoders
+do not emit.  The compressed chunk bodies come from the minimal block encoder
+below, written from the snappy block format description.  It shares no code
+with the Go package under test, but it is not the reference compressor, so
+the streams check decoding of independently constructed input rather than
+byte compatibility with the reference implementation.
+
+The script has no dependencies.  Run it from the repository root:
+
+    python3 testdata/generate.py
+"""
+
+import os
+import struct
+
+STREAM_ID = b"\xff\x06\x00\x00sNaPpY"
+
+HELLO = b"Hello, snappy framing!\n"
+LINES = b"".join(
+    b"line %03d: all work and no play makes jack a dull boy\n" % (i % 17)
+    for i in range(200)
+)
+
+
+def crc32c(data):
+    crc = 0xFFFFFFFF
+    for b in data:
+        crc ^= b
+        for _ in range(8):
+            crc = (crc >> 1) ^ (0x82F63B78 if crc & 1 else 0)
+    return crc ^ 0xFFFFFFFF
+
+
+def masked_checksum(data):
+    c = crc32c(data)
+    return ((((c >> 15) | (c << 17)) & 0xFFFFFFFF) + 0xA282EAD8) & 0xFFFFFFFF
+
+
+def varint(n):
+    out = bytearray()
+    while n >= 0x80:
+        out.append(n & 0x7F | 0x80)
+        n >>= 7
+    out.append(n)
+    return bytes(out)
+
+
+def literal(data):
+    n = len(data) - 1
+    if n < 60:
+        return bytes([n << 2]) + data
+    return bytes([61 << 2]) + struct.pack("<H", n) + data
+
+
+def copy(offset, length):
+    if 4 <= length <= 11 and offset < 2048:
+        return bytes([(offset >> 8) << 5 | (length - 4) << 2 | 0x01, offset & 0xFF])
+    return bytes([(length - 1) << 2 | 0x02]) + struct.pack("<H", offset)
+
+
+def compress(data):
+    """Encode data as a snappy block by greedy longest-match search.
+
+    Matches of 4 to 64 bytes within 65535 bytes are emitted as copies using
+    1-byte or 2-byte offsets, and everything else as literals of fewer than
+    65536 bytes.
+    """
+    out = bytearray(varint(len(data)))
+    lit = 0
+    i = 0
+    while i < len(data):
+        best, offset = 0, 0
+        for j in range(max(0, i - 65535), i):
+            n = 0
+            while n < 64 and i + n < len(data) and data[j + n] == data[i + n]:
+                n += 1
+            if n > best:
+                best, offset = n, i - j
+        if best < 4:
+            i += 1
+            continue
+        if lit < i:
+            out += literal(data[lit:i])
+        out += copy(offset, best)
+        i += best
+        lit = i
+    if lit < len(data):
+        out += literal(data[lit:])
+    return bytes(out)
+
+
+def chunk(kind, body):
+    return struct.pack("<I", kind | len(body) << 8) + body
+
+
+def uncompressed(data):
+    return chunk(0x01, struct.pack("<I", masked_checksum(data)) + data)
+
+
+def compressed(data):
+    return chunk(0x00, struct.pack("<I", masked_checksum(data)) + compress(data))
+
+
+GOLDEN = {
+    "empty": ([STREAM_ID], b""),
+    "hello": ([STREAM_ID, uncompressed(HELLO)], HELLO),
+    "mixed": (
+        [
+            STREAM_ID,
+            uncompressed(HELLO),
+            chunk(0xFE, bytes(7)),
+            compressed(LINES),
+            chunk(0x9A, b"skippable"),
+            STREAM_ID,
+            compressed(HELLO),
+        ],
+        HELLO + LINES + HELLO,
+    ),
+}
+
+
+def main():
+    testdata = os.path.dirname(os.path.abspath(__file__))
+    for name, (chunks, plain) in GOLDEN.items():
+        with open(os.path.join(testdata, name + ".sz"), "wb") as f:
+            f.write(b"".join(chunks))
+        with open(os.path.join(testdata, name + ".txt"), "wb") as f:
+            f.write(plain)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,46 @@
+package snappyframed
+
+// FrameEvent describes a chunk read by a Reader or written by a Writer, as
+// reported to a tracer set with SetTracer.
+type FrameEvent struct {
+	Type    byte // chunk type, such as ChunkCompressed or ChunkPadding
+	Size    int  // size of the chunk in the encoded stream, including its header
+	Decoded int  // number of bytes decoded from a data chunk, zero otherwise
+}
+
+// SetTracer sets a function called for each chunk sz reads from the
+// underlying reader, after the chunk has been processed successfully.  Chunks
+// which result in an error are not reported.  If fn is nil, the default,
+// chunks are not traced.  The tracer is retained when sz is Reset.
+func (sz *Reader) SetTracer(fn func(FrameEvent)) {
+	sz.opts.tracer = fn
+}
+
+// trace reports the current chunk to the tracer, if there is one.
+func (sz *Reader) trace(decoded int) {
+	if sz.opts.tracer != nil {
+		sz.opts.tracer(FrameEvent{
+			Type:    sz.hdr[0],
+			Size:    int(sz.nextOffset - sz.chunkOffset),
+			Decoded: decoded,
+		})
+	}
+}
+
+// SetTracer sets a function called for each chunk sz writes to the
+// underlying writer, after the chunk has been written.  If fn is nil, the
+// default, chunks are not traced.  The tracer is retained when sz is Reset.
+func (sz *Writer) SetTracer(fn func(FrameEvent)) {
+	sz.w.tracer = fn
+}
+
+// trace reports a chunk to the tracer, if there is one.
+func (sz *writer) trace(typ byte, size, decoded int) {
+	if sz.tracer != nil {
+		sz.tracer(FrameEvent{
+			Type:    typ,
+			Size:    size,
+			Decoded: decoded,
+		})
+	}
+}
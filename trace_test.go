@@ -0,0 +1,64 @@
+package snappyframed
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// This test checks that Reader and Writer tracers observe the same sequence
+// of chunks for a known stream.
+func TestTracer(t *testing.T) {
+	var wevents, revents []FrameEvent
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{
+		StreamChecksum: true,
+		Header:         &Header{Name: "trace"},
+	})
+	w.SetTracer(func(evt FrameEvent) { wevents = append(wevents, evt) })
+	w.Write(bytes.Repeat([]byte("a"), 100))
+	w.Flush()
+	w.Write(randBytes(t, 50))
+	w.PadTo(64)
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	r.SetTracer(func(evt FrameEvent) { revents = append(revents, evt) })
+	r.VerifyStreamChecksum(true)
+	_, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	types := []byte{
+		ChunkStreamIdentifier,
+		blockStreamHeader,
+		ChunkCompressed,
+		ChunkUncompressed,
+		ChunkPadding,
+		blockStreamChecksum,
+	}
+	if len(wevents) != len(types) {
+		t.Fatalf("writer events: %+v", wevents)
+	}
+	size := 0
+	for i, evt := range wevents {
+		if evt.Type != types[i] {
+			t.Errorf("writer event %d: type %#x (!= %#x)", i, evt.Type, types[i])
+		}
+		size += evt.Size
+	}
+	if size != buf.Len() {
+		t.Errorf("writer events total %d bytes (!= %d)", size, buf.Len())
+	}
+	if wevents[0].Size != len(streamID) || wevents[2].Decoded != 100 || wevents[3].Decoded != 50 || wevents[3].Size != 58 {
+		t.Errorf("writer events: %+v", wevents)
+	}
+	if !reflect.DeepEqual(revents, wevents) {
+		t.Errorf("reader events: %+v (!= %+v)", revents, wevents)
+	}
+}
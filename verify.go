@@ -0,0 +1,249 @@
+package snappyframed
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Verify reads a snappy framed stream from r and checks its integrity without
+// retaining decoded data.  Every data block is decoded and its checksum
+// verified.  Verify returns the total length of the decoded stream and the
+// first error encountered, if any.
+func Verify(r io.Reader) (decodedLen int64, err error) {
+	sz := NewReader(r)
+	for {
+		n, err := sz.nextFrame(ioutil.Discard)
+		decodedLen += int64(n)
+		if err == io.EOF {
+			return decodedLen, nil
+		}
+		if err != nil {
+			return decodedLen, err
+		}
+	}
+}
+
+// FrameStatus is the outcome of verifying a chunk with VerifyAll.
+type FrameStatus int
+
+// Statuses of chunks verified by VerifyAll.
+const (
+	FrameOK            FrameStatus = iota // the chunk is valid
+	FrameChecksumError                    // a data chunk's checksum does not match its data
+	FrameDecodeError                      // a data chunk could not be decoded
+	FrameInvalid                          // the bytes could not be parsed as a chunk
+	FrameTruncated                        // the stream ends within the chunk
+)
+
+var frameStatusNames = []string{
+	FrameOK:            "ok",
+	FrameChecksumError: "checksum error",
+	FrameDecodeError:   "decode error",
+	FrameInvalid:       "invalid",
+	FrameTruncated:     "truncated",
+}
+
+func (s FrameStatus) String() string {
+	if s < 0 || int(s) >= len(frameStatusNames) {
+		return fmt.Sprintf("FrameStatus(%d)", int(s))
+	}
+	return frameStatusNames[s]
+}
+
+// FrameResult describes a chunk examined by VerifyAll, or a range of bytes
+// skipped while resynchronizing after an invalid chunk.
+type FrameResult struct {
+	Offset  int64       // offset of the chunk in the stream
+	Size    int64       // size of the chunk, including its header
+	Type    byte        // chunk type; the type of the first byte skipped if invalid
+	Decoded int         // number of bytes decoded from a valid data chunk
+	Status  FrameStatus // outcome of verifying the chunk
+	Err     error       // error describing a Status other than FrameOK
+}
+
+// VerifyAll reads a snappy framed stream from r and checks the integrity of
+// every chunk, continuing past corrupt chunks.  The returned results describe
+// each chunk in the stream, in order.  VerifyAll returns an error only if
+// reading r fails; problems with the stream are reported in the results.
+//
+// A data chunk whose checksum does not match, or which cannot be decoded, is
+// skipped using the length in its header.  When a chunk header itself is
+// invalid, such as one of a reserved unskippable type or with an impossible
+// length, VerifyAll resynchronizes by scanning forward one byte at a time for
+// a valid stream identifier or a data chunk whose checksum matches, and
+// reports the skipped bytes as a single FrameInvalid result.  Because the
+// format has no synchronization markers resynchronization is best-effort.  A
+// corrupt length which remains plausible goes undetected until the following
+// chunk fails to verify, and valid chunks may be skipped while scanning.
+// Skippable chunks are not accepted while scanning, as random bytes easily
+// resemble them.
+func VerifyAll(r io.Reader) (results []FrameResult, err error) {
+	v := &verifier{br: bufio.NewReaderSize(r, int(maxEncodedBlockSize)+blockHeaderSize)}
+	for {
+		done, err := v.next()
+		if err != nil {
+			return v.results, err
+		}
+		if done {
+			return v.results, nil
+		}
+	}
+}
+
+// verifier holds the state of VerifyAll.
+type verifier struct {
+	br      *bufio.Reader
+	offset  int64
+	dst     []byte
+	results []FrameResult
+	skipped *FrameResult // bytes skipped while resynchronizing
+}
+
+// next verifies the chunk at the current offset.  next returns true when the
+// end of the stream has been reached.
+func (v *verifier) next() (bool, error) {
+	hdr, err := v.br.Peek(4)
+	if len(hdr) < 4 {
+		if err == io.EOF {
+			v.end(len(hdr))
+			return true, nil
+		}
+		return false, err
+	}
+
+	typ, length := DecodeHeader(hdr)
+	res := FrameResult{Offset: v.offset, Size: int64(4 + length), Type: typ}
+	if v.offset == 0 && typ != blockStreamIdentifier {
+		v.results = append(v.results, FrameResult{
+			Type:   typ,
+			Status: FrameInvalid,
+			Err:    errMissingStreamID,
+		})
+	}
+
+	var chunk []byte
+	switch {
+	case typ == blockCompressed || typ == blockUncompressed:
+		if length < minDataBlockSize || length > int(maxEncodedBlockSize)+4 {
+			return v.resync()
+		}
+		chunk, err = v.br.Peek(4 + length)
+		if err == io.EOF && v.skipped != nil {
+			return v.resync()
+		}
+		if err == io.EOF {
+			v.end(len(chunk))
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		dec, err := DecodeBlock(v.dst[:0], chunk)
+		if cap(dec) > cap(v.dst) {
+			v.dst = dec
+		}
+		var cerr *ChecksumError
+		switch {
+		case err == nil:
+			res.Decoded = len(dec)
+		case v.skipped != nil:
+			return v.resync()
+		case errors.As(err, &cerr):
+			cerr.Offset = v.offset
+			res.Status, res.Err = FrameChecksumError, err
+		default:
+			res.Status, res.Err = FrameDecodeError, err
+		}
+	case typ == blockStreamIdentifier:
+		if 4+length != len(streamID) {
+			return v.resync()
+		}
+		chunk, err = v.br.Peek(len(streamID))
+		if err == io.EOF && v.skipped != nil {
+			return v.resync()
+		}
+		if err == io.EOF {
+			v.end(len(chunk))
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(chunk, streamID) {
+			return v.resync()
+		}
+	case isSkippable(typ) && v.skipped == nil:
+		// skippable chunks may be longer than the buffer and are not peeked.
+	default:
+		return v.resync()
+	}
+
+	v.flushSkipped()
+	n, err := v.br.Discard(4 + length)
+	v.offset += int64(n)
+	if err == io.EOF {
+		res.Size = int64(n)
+		res.Status, res.Err = FrameTruncated, io.ErrUnexpectedEOF
+		v.results = append(v.results, res)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	v.results = append(v.results, res)
+	return false, nil
+}
+
+// resync skips the byte at the current offset, adding it to the range of
+// skipped bytes.
+func (v *verifier) resync() (bool, error) {
+	if v.skipped == nil {
+		b, _ := v.br.Peek(1)
+		v.skipped = &FrameResult{
+			Offset: v.offset,
+			Type:   b[0],
+			Status: FrameInvalid,
+			Err:    errInvalidFrame,
+		}
+	}
+	_, err := v.br.Discard(1)
+	if err != nil {
+		return false, err
+	}
+	v.offset++
+	v.skipped.Size++
+	return false, nil
+}
+
+// flushSkipped records the range of skipped bytes, if there is one.
+func (v *verifier) flushSkipped() {
+	if v.skipped != nil {
+		v.results = append(v.results, *v.skipped)
+		v.skipped = nil
+	}
+}
+
+// end records the final n bytes of the stream, which do not form a complete
+// chunk.
+func (v *verifier) end(n int) {
+	if v.skipped != nil {
+		v.skipped.Size += int64(n)
+		v.flushSkipped()
+		return
+	}
+	if n == 0 {
+		return
+	}
+	b, _ := v.br.Peek(1)
+	v.results = append(v.results, FrameResult{
+		Offset: v.offset,
+		Size:   int64(n),
+		Type:   b[0],
+		Status: FrameTruncated,
+		Err:    io.ErrUnexpectedEOF,
+	})
+}
@@ -0,0 +1,120 @@
+package snappyframed
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		p    []byte
+	}{
+		{"empty", nil},
+		{"manpage", testDataMan},
+		{"json", testDataJSON},
+		{"constant", make([]byte, 4*maxBlockSize+1)},
+	} {
+		enc, err := encodeStreamBytes(test.p, false)
+		if err != nil {
+			t.Errorf("%s: encode: %v", test.name, err)
+			continue
+		}
+		n, err := Verify(bytes.NewReader(enc))
+		if err != nil {
+			t.Errorf("%s: verify: %v", test.name, err)
+		}
+		if n != int64(len(test.p)) {
+			t.Errorf("%s: decoded length %d (!= %d)", test.name, n, len(test.p))
+		}
+	}
+}
+
+// This test checks that Verify detects a corrupt checksum.
+func TestVerify_checksum(t *testing.T) {
+	first := []byte("an intact block")
+	second := compressedChunk(t, []byte("a corrupt block"))
+	second[5] ^= 0xff
+	stream := bytes.Join([][]byte{
+		streamID,
+		compressedChunk(t, first),
+		second,
+		compressedChunk(t, []byte("an unverified block")),
+	}, nil)
+
+	n, err := Verify(bytes.NewReader(stream))
+	if err == nil {
+		t.Fatalf("verify: expected error")
+	}
+	if n != int64(len(first)) {
+		t.Fatalf("verify: decoded length %d (!= %d)", n, len(first))
+	}
+}
+
+// This test checks that VerifyAll reports every corrupt chunk in a stream and
+// resynchronizes after invalid chunk headers.
+func TestVerifyAll(t *testing.T) {
+	badChecksum := compressedChunk(t, []byte("a corrupt checksum"))
+	badChecksum[5] ^= 0xff
+	badData := compressedChunk(t, []byte("undecodable data"))
+	badData[8] = 0xff // the encoded length
+	garbage := []byte{0x42, 0xff, 0xff, 0xff, 0x01, 0x02, 0x03}
+	chunks := [][]byte{
+		streamID,
+		compressedChunk(t, []byte("first")),
+		badChecksum,
+		opaqueChunk(blockPadding, 20),
+		badData,
+		garbage,
+		uncompressedChunk(t, []byte("recovered")),
+		badChecksum,
+		compressedChunk(t, []byte("last")),
+	}
+	stream := bytes.Join(chunks, nil)
+
+	results, err := VerifyAll(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	expect := []struct {
+		status  FrameStatus
+		typ     byte
+		decoded int
+	}{
+		{FrameOK, blockStreamIdentifier, 0},
+		{FrameOK, blockCompressed, 5},
+		{FrameChecksumError, blockCompressed, 0},
+		{FrameOK, blockPadding, 0},
+		{FrameDecodeError, blockCompressed, 0},
+		{FrameInvalid, 0x42, 0},
+		{FrameOK, blockUncompressed, 9},
+		{FrameChecksumError, blockCompressed, 0},
+		{FrameOK, blockCompressed, 4},
+	}
+	if len(results) != len(expect) {
+		t.Fatalf("results: %+v", results)
+	}
+	var offset int64
+	for i, res := range results {
+		if res.Status != expect[i].status || res.Type != expect[i].typ || res.Decoded != expect[i].decoded {
+			t.Errorf("result %d: %+v", i, res)
+		}
+		if res.Offset != offset || res.Size != int64(len(chunks[i])) {
+			t.Errorf("result %d: bytes %d-%d (!= %d-%d)", i, res.Offset, res.Offset+res.Size, offset, offset+int64(len(chunks[i])))
+		}
+		if (res.Err == nil) != (res.Status == FrameOK) {
+			t.Errorf("result %d: %v: %v", i, res.Status, res.Err)
+		}
+		offset += int64(len(chunks[i]))
+	}
+
+	// a truncated final chunk.
+	results, err = VerifyAll(bytes.NewReader(stream[:len(stream)-2]))
+	if err != nil {
+		t.Fatalf("verify truncated: %v", err)
+	}
+	last := results[len(results)-1]
+	if len(results) != len(expect) || last.Status != FrameTruncated || last.Size != int64(len(chunks[len(chunks)-1])-2) {
+		t.Fatalf("truncated result: %+v", last)
+	}
+}
@@ -2,8 +2,8 @@ package snappyframed
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
+	"hash"
 	"hash/crc32"
 	"io"
 
@@ -15,9 +15,93 @@ var errClosed = fmt.Errorf("closed")
 // Writer is an io.WriteCloser, Data written to a Writer is compressed and
 // flushed to an underlying io.Writer.
 type Writer struct {
-	err error
-	w   *writer
-	bw  *bufio.Writer
+	err     error
+	w       *writer
+	bw      writeBuffer
+	closer  io.Closer // closed by Close (see NewChannelWriter)
+	flushAt int       // see SetFlushPolicy
+
+	noReadFrom bool // see DisableReadFrom
+}
+
+// writeBuffer is the buffering layer through which a Writer passes data to its
+// internal writer.  It is a *bufio.Writer except for Writers created with
+// NewBlockWriter.
+type writeBuffer interface {
+	io.Writer
+	io.ReaderFrom
+	Buffered() int
+	Available() int
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// WriterOptions configures a Writer created with NewWriterOptions.  The zero
+// value is the configuration used by NewWriter.
+type WriterOptions struct {
+	// StreamChecksum causes the Writer to maintain a CRC-32C checksum of all
+	// data written to it.  On Close the checksum is written in a reserved
+	// skippable chunk which may be verified by a Reader configured with
+	// VerifyStreamChecksum.  Unlike block checksums, the stream checksum
+	// detects blocks which have been removed or reordered.
+	StreamChecksum bool
+
+	// Header, if not nil, is written in a reserved skippable chunk immediately
+	// following the stream identifier.  It may be read with Reader.Header.
+	Header *Header
+
+	// Checksum, if not nil, replaces the masked CRC-32C checksum stored in
+	// each data chunk.  Streams written with a custom Checksum are not
+	// conformant and must be read by a Reader configured with the same
+	// function.
+	Checksum func([]byte) uint32
+
+	// ChecksumKind selects the algorithm computing the checksum stored in
+	// each data chunk when Checksum is nil.  The default is ChecksumCRC32C,
+	// which is required by the framing format.  NewWriterOptions panics if
+	// ChecksumKind is not a known kind.
+	ChecksumKind ChecksumKind
+
+	// AlwaysCompress causes every data chunk to be written compressed, even
+	// when compression expands the data.  By default such data is written in
+	// uncompressed chunks.  Streams written with AlwaysCompress are conformant
+	// but are larger when data is incompressible.
+	AlwaysCompress bool
+
+	// MinCompressionRatio is the fraction of a block's size which compression
+	// must save for the block to be written compressed.  For example, with a
+	// MinCompressionRatio of 0.1 blocks are written uncompressed unless their
+	// compressed size is at most 90% of their decoded size, sparing readers
+	// the cost of decoding blocks which barely compress.  By default blocks
+	// are written compressed whenever compression reduces their size.
+	MinCompressionRatio float64
+
+	// BufferSize is the size of the buffer in which written data is
+	// coalesced before it is encoded.  A full buffer is encoded in blocks of
+	// at most MaxBlockSize bytes.  Values less than MaxBlockSize, including
+	// zero, are treated as MaxBlockSize so that a full block can always be
+	// assembled.
+	BufferSize int
+
+	// FlushMarkers causes Flush, FlushFrame, and Close to write an empty
+	// padding chunk after any data blocks written since the previous marker.
+	// Markers delimit the data of each Flush so that a Reader may read it as
+	// a single message with NextMessage.  Readers which do not look for
+	// markers ignore them like any other padding.
+	FlushMarkers bool
+
+	// OmitStreamID causes the Writer to never write the stream identifier,
+	// saving 10 bytes per stream when many small streams are stored.  The
+	// output is not conformant and can only be decoded by a Reader configured
+	// with AllowMissingStreamID.
+	OmitStreamID bool
+
+	// EagerStreamID causes the stream identifier, and Header if any, to be
+	// written by NewWriterOptions and Reset instead of preceding the first
+	// data block, so that a reader may recognize the stream before any data
+	// is written.  An error writing the identifier is returned by the first
+	// method called on the Writer.
+	EagerStreamID bool
 }
 
 // NewWriter returns a new Writer.  Data written to the returned Writer is
@@ -27,35 +111,181 @@ type Writer struct {
 // The caller is responsible for calling Flush or Close after all writes have
 // completed to guarantee all data has been encoded and written to w.
 func NewWriter(w io.Writer) *Writer {
+	return NewWriterOptions(w, nil)
+}
+
+// NewWriterOptions is like NewWriter but configures the returned Writer with
+// opts.  If opts is nil the returned Writer is equivalent to one returned by
+// NewWriter.  The configuration is retained when the Writer is Reset.
+func NewWriterOptions(w io.Writer, opts *WriterOptions) *Writer {
+	sz := newWriter(w)
+	bufSize := maxBlockSize
+	if opts != nil {
+		if opts.BufferSize > bufSize {
+			bufSize = opts.BufferSize
+		}
+		sz.opts = *opts
+		if opts.Checksum == nil {
+			sz.opts.Checksum = opts.ChecksumKind.checksum()
+		}
+		if opts.Header != nil {
+			sz.header, sz.headerErr = opts.Header.marshal()
+		}
+	}
+	wr := &Writer{
+		w:  sz,
+		bw: bufio.NewWriterSize(sz, bufSize),
+	}
+	wr.writeEagerStreamID()
+	return wr
+}
+
+// writeEagerStreamID writes the stream identifier if sz was created with the
+// EagerStreamID option.
+func (sz *Writer) writeEagerStreamID() {
+	if sz.w.opts.EagerStreamID && sz.w.writer != nil {
+		sz.err = sz.w.writeStreamID()
+	}
+}
+
+// NewBlockWriter returns a Writer that does not buffer data.  Each call to
+// Write encodes its data immediately, as a single data block if it contains
+// at most MaxBlockSize bytes and otherwise as consecutive blocks of
+// MaxBlockSize bytes.  NewBlockWriter avoids the copy through the internal
+// buffer of a Writer returned by NewWriter when callers already write data
+// in blocks of MaxBlockSize bytes.  Writing smaller amounts of data results in
+// poor compression.
+//
+// Flush has no effect on a Writer returned by NewBlockWriter other than to
+// return any previous error, and Buffered and Available always return zero.
+func NewBlockWriter(w io.Writer) *Writer {
 	sz := newWriter(w)
 	return &Writer{
 		w:  sz,
-		bw: bufio.NewWriterSize(sz, maxBlockSize),
+		bw: unbufferedWriter{sz},
 	}
 }
 
+// NewAppendWriter is like NewWriter but, if alreadyHasStreamID is true, does
+// not write a stream identifier before the first data block.  NewAppendWriter
+// allows data to be appended to an existing stream, such as a file opened for
+// appending, so that the original and appended data are read as one
+// contiguous stream.  The caller is responsible for ensuring that w ends on a
+// chunk boundary.  Reset discards the setting; a Writer which has been Reset
+// writes a stream identifier like any other.
+func NewAppendWriter(w io.Writer, alreadyHasStreamID bool) *Writer {
+	sz := NewWriter(w)
+	sz.w.sentStreamID = alreadyHasStreamID
+	return sz
+}
+
+// unbufferedWriter is the writeBuffer of a Writer created with NewBlockWriter.
+// It passes data directly to an internal writer.
+type unbufferedWriter struct {
+	w *writer
+}
+
+func (u unbufferedWriter) Write(p []byte) (int, error) {
+	return u.w.Write(p)
+}
+
+func (u unbufferedWriter) ReadFrom(r io.Reader) (int64, error) {
+	return u.w.ReadFrom(r)
+}
+
+func (u unbufferedWriter) Buffered() int {
+	return 0
+}
+
+func (u unbufferedWriter) Available() int {
+	return 0
+}
+
+func (u unbufferedWriter) Flush() error {
+	return nil
+}
+
+func (u unbufferedWriter) Reset(w io.Writer) {
+}
+
 // ReadFrom implements the io.ReaderFrom interface used by io.Copy. It encodes
 // data read from r as a snappy framed stream and writes the result to the
 // underlying io.Writer.  ReadFrom returns the number number of bytes read,
 // along with any error encountered (other than io.EOF).
+//
+// When sz has no buffered data ReadFrom reads blocks of MaxBlockSize bytes
+// from r and encodes them directly, avoiding a copy through the internal
+// buffer.  In this case the final (short) block read from r is encoded
+// immediately instead of being buffered.
+//
+// If reading r fails, all data read from r is encoded and written to the
+// underlying io.Writer before ReadFrom returns the error.  Errors reading r do
+// not affect sz, which may still be written to or closed to produce a valid
+// stream.
+//
+// io.Copy and similar functions call ReadFrom automatically when sz is the
+// destination.  After DisableReadFrom, ReadFrom copies r to sz with io.Copy as
+// if sz did not implement io.ReaderFrom.
 func (sz *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if sz.noReadFrom {
+		return io.Copy(struct{ io.Writer }{sz}, r)
+	}
 	if sz.err != nil {
 		return 0, sz.err
 	}
+	if sz.w.writer == nil {
+		return 0, ErrNilTarget
+	}
 
 	var n int64
-	n, sz.err = sz.bw.ReadFrom(r)
+	var err error
+	if sz.bw.Buffered() == 0 {
+		n, err = sz.w.ReadFrom(r)
+	} else {
+		n, err = sz.bw.ReadFrom(r)
+	}
+	if err != nil && sz.w.err == nil {
+		// the error came from r.  encode the data read before it.  a
+		// bufio.Writer which passed r to sz.w retains the error, but it
+		// has no buffered data and can be reset without loss.
+		if sz.bw.Buffered() == 0 {
+			sz.bw.Reset(sz.w)
+		}
+		sz.err = sz.bw.Flush()
+		if sz.err != nil {
+			return n, sz.err
+		}
+		return n, err
+	}
+	sz.err = err
 	return n, sz.err
 }
 
+// DisableReadFrom causes ReadFrom to read data through the generic copy path
+// used by io.Copy, writing it to sz with Write, instead of reading whole blocks
+// directly.  It is intended for troubleshooting suspected problems in
+// ReadFrom.  The setting is retained when sz is Reset.
+func (sz *Writer) DisableReadFrom() {
+	sz.noReadFrom = true
+}
+
 // Reset discards internal state and sets the underlying writer to w.  After
 // Reset returns the writer is equivalent to one returned by NewWriter(w).
 // Reusing writers with Reset can significantly reduce allocation overhead in
 // applications making heavy use of snappy framed format streams.
+//
+// Reset matches the signature of Reset methods on Writers in the compress
+// packages, such as gzip.Writer.Reset, so that a Writer satisfies
+// interface{ Reset(io.Writer) }.
+//
+// A Writer returned by NewChannelWriter writes to w after Reset, and its
+// channel is no longer closed by Close.
 func (sz *Writer) Reset(w io.Writer) {
 	sz.err = nil
+	sz.closer = nil
 	sz.w.Reset(w)
 	sz.bw.Reset(sz.w)
+	sz.writeEagerStreamID()
 }
 
 // Write compresses the bytes of p and writes sequence of encoded chunks to the
@@ -68,49 +298,299 @@ func (sz *Writer) Write(p []byte) (int, error) {
 	if sz.err != nil {
 		return 0, sz.err
 	}
+	if sz.w.writer == nil {
+		return 0, ErrNilTarget
+	}
 
 	_, sz.err = sz.bw.Write(p)
 	if sz.err != nil {
 		return 0, sz.err
 	}
 
+	if sz.flushAt > 0 && sz.bw.Buffered() >= sz.flushAt {
+		sz.err = sz.flush()
+		if sz.err != nil {
+			return 0, sz.err
+		}
+	}
+
 	return len(p), nil
 }
 
+// SetFlushPolicy causes Write to flush sz, as Flush does, whenever the data
+// buffered in sz reaches maxBuffered bytes.  Lowering maxBuffered reduces the
+// latency of data written to sz at the cost of smaller blocks, which compress
+// less effectively.  If maxBuffered is not positive data is only encoded when
+// a full block is buffered or sz is flushed, which is the default.  The
+// policy is retained when sz is Reset.
+func (sz *Writer) SetFlushPolicy(maxBuffered int) {
+	sz.flushAt = maxBuffered
+}
+
+// SetBlockSize flushes any data buffered in sz and changes the maximum number
+// of bytes encoded in each subsequent data block to n.  The internal buffer is
+// resized to hold n bytes, or the BufferSize given to NewWriterOptions if that
+// is larger, so that small blocks are written as soon as they fill.  Small
+// blocks reduce latency at the cost of compression.  Blocks of different sizes
+// may be mixed freely in a stream.  SetBlockSize returns an error if n is not
+// between 1 and MaxBlockSize.  The block size is retained when sz is Reset.
+//
+// Blocks written by EncodeReaderAt are always MaxBlockSize bytes.
+func (sz *Writer) SetBlockSize(n int) error {
+	if n < 1 || n > maxBlockSize {
+		return fmt.Errorf("invalid block size %d", n)
+	}
+	if sz.err != nil {
+		return sz.err
+	}
+
+	if bw, ok := sz.bw.(*bufio.Writer); ok {
+		sz.err = bw.Flush()
+		if sz.err != nil {
+			return sz.err
+		}
+		bufSize := n
+		if sz.w.opts.BufferSize > bufSize {
+			bufSize = sz.w.opts.BufferSize
+		}
+		if bufSize != bw.Size() {
+			sz.bw = bufio.NewWriterSize(sz.w, bufSize)
+		}
+	}
+	sz.w.blockSize = n
+	return nil
+}
+
+// SetPlaintextHash causes all data encoded by sz to also be written to h, so
+// that a digest of the uncompressed stream is computed without a second pass.
+// Data is written to h as it is encoded, so h.Sum includes data buffered in sz
+// only after sz is flushed or closed.  If h is nil, the default, no hash is
+// computed.  The hash is retained when sz is Reset but h itself is not reset.
+func (sz *Writer) SetPlaintextHash(h hash.Hash) {
+	sz.w.hash = h
+}
+
+// Buffered returns the number of (decoded) source bytes buffered internally
+// which have not yet been encoded and written to the underlying io.Writer.
+func (sz *Writer) Buffered() int {
+	return sz.bw.Buffered()
+}
+
+// Available returns the number of bytes that may be written to sz before the
+// internal buffer fills and a block is encoded.
+func (sz *Writer) Available() int {
+	return sz.bw.Available()
+}
+
 // Flush encodes any (decoded) source data buffered interanally in the Writer
 // and writes a chunk containing the result to the underlying io.Writer.
 func (sz *Writer) Flush() error {
-	if sz.err == nil {
-		sz.err = sz.bw.Flush()
+	if sz.err != nil {
+		return sz.err
+	}
+	if sz.w.writer == nil {
+		return ErrNilTarget
 	}
 
+	sz.err = sz.flush()
 	return sz.err
 }
 
-// Close flushes the Writer and tears down internal data structures.  Close
-// does not close the underlying io.Writer.
-func (sz *Writer) Close() error {
+// FlushFrame is like Flush but guarantees that the stream identifier has been
+// written to the underlying io.Writer, even if no data has been written to
+// sz.  Buffered data is encoded in as few blocks as the block size allows and
+// flushed.  If no data is buffered no data frame is written.
+//
+// FlushFrame is useful for interactive protocols in which a reader must
+// observe the beginning of a stream before any data is available.
+func (sz *Writer) FlushFrame() error {
 	if sz.err != nil {
 		return sz.err
 	}
+	if sz.w.writer == nil {
+		return ErrNilTarget
+	}
 
-	sz.err = sz.bw.Flush()
+	sz.err = sz.flush()
 	if sz.err != nil {
 		return sz.err
 	}
 
+	sz.err = sz.w.writeStreamID()
+	return sz.err
+}
+
+// PadTo flushes sz and writes padding chunks so that the total number of bytes
+// written to the underlying io.Writer is a multiple of align.  Padding is
+// ignored by Readers and does not affect the decoded stream.  Because a
+// padding chunk is at least 5 bytes, so that it cannot be mistaken for a flush
+// marker, PadTo may write up to align+4 bytes of padding.  PadTo returns an error if align is not positive.
+func (sz *Writer) PadTo(align int) error {
+	if align <= 0 {
+		return fmt.Errorf("invalid alignment %d", align)
+	}
+
+	err := sz.Flush()
+	if err != nil {
+		return err
+	}
+
+	sz.err = sz.w.padTo(align)
+	return sz.err
+}
+
+// Close flushes the Writer and tears down internal data structures.  If the
+// Writer was created with the StreamChecksum option the stream checksum is
+// written before Close returns.  Close does not close the underlying
+// io.Writer.
+func (sz *Writer) Close() error {
+	err := sz.finish()
+	if err != nil {
+		return sz.close(err)
+	}
+
 	sz.err = errClosed
+	return sz.close(nil)
+}
+
+// CloseAligned is like Close but, after the stream is complete, writes
+// padding so that the total number of bytes written to the underlying
+// io.Writer is a multiple of align, as PadTo does.  CloseAligned does not close
+// the underlying io.Writer.  CloseAligned returns an error if align is not
+// positive.
+func (sz *Writer) CloseAligned(align int) error {
+	if align <= 0 {
+		return fmt.Errorf("invalid alignment %d", align)
+	}
+
+	err := sz.finish()
+	if err != nil {
+		return sz.close(err)
+	}
+
+	sz.err = sz.w.padTo(align)
+	if sz.err != nil {
+		return sz.close(sz.err)
+	}
+
+	sz.err = errClosed
+	return sz.close(nil)
+}
+
+// close releases resources held for the underlying io.Writer when sz is
+// closed, whether or not the stream is complete.  The resources are released
+// only once.  close returns err if it is non-nil and otherwise any error
+// releasing the resources.
+func (sz *Writer) close(err error) error {
+	if sz.closer == nil {
+		return err
+	}
+	cerr := sz.closer.Close()
+	sz.closer = nil
+	if err != nil {
+		return err
+	}
+	return cerr
+}
+
+// finish flushes sz and writes the stream checksum if sz was created with the
+// StreamChecksum option.
+func (sz *Writer) finish() error {
+	if sz.err != nil {
+		return sz.err
+	}
+	if sz.w.writer == nil {
+		return ErrNilTarget
+	}
+
+	sz.err = sz.flush()
+	if sz.err != nil {
+		return sz.err
+	}
+
+	if sz.w.opts.StreamChecksum {
+		sz.err = sz.w.writeStreamChecksum()
+		if sz.err != nil {
+			return sz.err
+		}
+	}
 	return nil
 }
 
+// flush encodes buffered data and, if sz was created with the FlushMarkers
+// option, writes a flush marker.
+func (sz *Writer) flush() error {
+	err := sz.bw.Flush()
+	if err != nil {
+		return err
+	}
+	if sz.w.opts.FlushMarkers {
+		return sz.w.writeFlushMarker()
+	}
+	return nil
+}
+
+// WriterStats summarizes the blocks a Writer has encoded and written to its
+// underlying io.Writer.  Data buffered internally and not yet encoded is not
+// reflected in WriterStats.
+type WriterStats struct {
+	// BlocksTotal is the number of data blocks written.
+	BlocksTotal int64
+
+	// BlocksUncompressed is the number of data blocks written uncompressed
+	// because snappy could not reduce their size.
+	BlocksUncompressed int64
+
+	// BytesIn is the number of (decoded) source bytes encoded in data blocks.
+	BytesIn int64
+
+	// BytesOut is the number of bytes written to the underlying io.Writer,
+	// including the stream identifier, block headers, and padding.
+	BytesOut int64
+
+	// Frames is the number of chunks written, including the stream identifier,
+	// padding, and other skippable chunks as well as data blocks.
+	Frames int64
+}
+
+// Ratio returns the realized compression ratio, BytesIn/BytesOut.  Ratio
+// returns 0 if no bytes have been written.
+func (s WriterStats) Ratio() float64 {
+	if s.BytesOut == 0 {
+		return 0
+	}
+	return float64(s.BytesIn) / float64(s.BytesOut)
+}
+
+// Stats returns statistics about the blocks written by sz since it was created
+// or last Reset.
+func (sz *Writer) Stats() WriterStats {
+	return sz.w.stats
+}
+
 type writer struct {
 	writer io.Writer
 	err    error
 
 	hdr []byte
+	src []byte // allocated by ReadFrom
 	dst []byte
 
+	blockSize int // maximum size of blocks encoded by Write and ReadFrom
+
 	sentStreamID bool
+	streamCRC    uint32 // unmasked checksum of all data written
+	unmarked     bool   // data blocks written since the last flush marker
+	sizeHint     int64  // decoded size hint written after the identifier
+	hasSizeHint  bool   // see Writer.SetDecodedSizeHint
+
+	stats WriterStats
+
+	opts      WriterOptions
+	header    []byte // encoded opts.Header
+	headerErr error  // error encoding opts.Header
+	tracer    func(FrameEvent)
+	hash      hash.Hash // receives data as it is encoded
 }
 
 // newWriter returns an io.Writer that writes its input to an underlying
@@ -129,7 +609,9 @@ func newWriter(w io.Writer) *writer {
 		writer: w,
 
 		hdr: make([]byte, 8),
-		dst: make([]byte, 4096),
+		dst: make([]byte, maxEncodedBlockSize),
+
+		blockSize: maxBlockSize,
 	}
 }
 
@@ -140,6 +622,11 @@ func newWriter(w io.Writer) *writer {
 func (sz *writer) Reset(w io.Writer) {
 	sz.err = nil
 	sz.sentStreamID = false
+	sz.streamCRC = 0
+	sz.unmarked = false
+	sz.sizeHint = 0
+	sz.hasSizeHint = false
+	sz.stats = WriterStats{}
 	sz.writer = w
 }
 
@@ -147,9 +634,12 @@ func (sz *writer) Write(p []byte) (int, error) {
 	if sz.err != nil {
 		return 0, sz.err
 	}
+	if sz.writer == nil {
+		return 0, ErrNilTarget
+	}
 
 	total := 0
-	size := maxBlockSize
+	size := sz.blockSize
 	var n int
 	for i := 0; i < len(p); i += n {
 		if i+size > len(p) {
@@ -165,6 +655,40 @@ func (sz *writer) Write(p []byte) (int, error) {
 	return total, nil
 }
 
+// ReadFrom reads blocks of sz.blockSize bytes from r and encodes them.  The
+// final block read from r may be short.  ReadFrom returns the number of bytes
+// read from r and any error encountered other than io.EOF.
+func (sz *writer) ReadFrom(r io.Reader) (int64, error) {
+	if sz.err != nil {
+		return 0, sz.err
+	}
+	if sz.writer == nil {
+		return 0, ErrNilTarget
+	}
+
+	if sz.src == nil {
+		sz.src = make([]byte, maxBlockSize)
+	}
+
+	var total int64
+	for {
+		n, err := io.ReadFull(r, sz.src[:sz.blockSize])
+		if n > 0 {
+			_, sz.err = sz.write(sz.src[:n])
+			if sz.err != nil {
+				return total, sz.err
+			}
+			total += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
 // write attempts to encode p as a block and write it to the underlying writer.
 // The returned int may not equal p's length if compression below
 // maxBlockSize-4 could not be achieved.
@@ -172,52 +696,294 @@ func (sz *writer) write(p []byte) (int, error) {
 	var err error
 
 	if len(p) > maxBlockSize {
-		return 0, errors.New(fmt.Sprintf("block too large %d > %d", len(p), maxBlockSize))
+		return 0, fmt.Errorf("%w: length %d > %d", ErrBlockTooLarge, len(p), maxBlockSize)
 	}
 
 	sz.dst = sz.dst[:cap(sz.dst)] // Encode does dumb resize w/o context. reslice avoids alloc.
-	sz.dst = snappy.Encode(sz.dst, p)
+	sz.dst, err = snappyEncode(sz.dst, p)
+	if err != nil {
+		return 0, fmt.Errorf("snappy encode: %w", err)
+	}
 	block := sz.dst
 	n := len(p)
 	compressed := true
 
-	// check for data which is better left uncompressed.  this is determined if
-	// the encoded content is longer than the source.
-	if len(sz.dst) >= len(p) {
+	// check for data which is better left uncompressed.
+	if !sz.opts.compress(len(sz.dst), len(p)) {
 		compressed = false
 		block = p[:n]
 	}
 
-	if !sz.sentStreamID {
-		_, err := sz.writer.Write(streamID)
-		if err != nil {
-			return 0, err
-		}
-		sz.sentStreamID = true
+	err = sz.writeStreamID()
+	if err != nil {
+		return 0, err
 	}
 
 	// set the block type
 	if compressed {
-		writeHeader(sz.hdr, blockCompressed, block, p[:n])
+		writeHeader(sz.hdr, blockCompressed, block, sz.checksum(p[:n]))
 	} else {
-		writeHeader(sz.hdr, blockUncompressed, block, p[:n])
+		writeHeader(sz.hdr, blockUncompressed, block, sz.checksum(p[:n]))
 	}
 
-	_, err = sz.writer.Write(sz.hdr)
+	err = sz.writeFull(sz.hdr)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("writing block header: %w", err)
 	}
 
-	_, err = sz.writer.Write(block)
+	err = sz.writeFull(block)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("writing block data: %w", err)
+	}
+
+	if sz.opts.StreamChecksum {
+		sz.streamCRC = crc32.Update(sz.streamCRC, crcTable, p[:n])
 	}
+	if sz.hash != nil {
+		sz.hash.Write(p[:n])
+	}
+
+	sz.unmarked = true
+	sz.stats.Frames++
+	sz.stats.BlocksTotal++
+	if !compressed {
+		sz.stats.BlocksUncompressed++
+	}
+	sz.stats.BytesIn += int64(n)
+	sz.stats.BytesOut += int64(len(sz.hdr) + len(block))
+	sz.trace(sz.hdr[0], len(sz.hdr)+len(block), n)
 
 	return n, nil
 }
 
+// snappyEncode encodes block data.  It is a variable so that tests may simulate
+// a faulty encoder.
+var snappyEncode = func(dst, src []byte) ([]byte, error) {
+	return snappy.Encode(dst, src), nil
+}
+
+// writeFull writes all of p to the underlying writer.  Writes which return a
+// short count without an error are retried with the remaining data, and
+// io.ErrShortWrite is returned if the underlying writer makes no progress.
+func (sz *writer) writeFull(p []byte) error {
+	for len(p) > 0 {
+		n, err := sz.writer.Write(p)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		p = p[n:]
+	}
+	return nil
+}
+
+// writeFrame writes frame, a data chunk encoding p, to the underlying writer.
+func (sz *writer) writeFrame(frame, p []byte, compressed bool) error {
+	err := sz.writeFull(frame)
+	if err != nil {
+		return fmt.Errorf("writing block: %w", err)
+	}
+
+	if sz.opts.StreamChecksum {
+		sz.streamCRC = crc32.Update(sz.streamCRC, crcTable, p)
+	}
+	if sz.hash != nil {
+		sz.hash.Write(p)
+	}
+
+	sz.unmarked = true
+	sz.stats.Frames++
+	sz.stats.BlocksTotal++
+	if !compressed {
+		sz.stats.BlocksUncompressed++
+	}
+	sz.stats.BytesIn += int64(len(p))
+	sz.stats.BytesOut += int64(len(frame))
+	sz.trace(frame[0], len(frame), len(p))
+	return nil
+}
+
+// writeStreamID writes the stream identifier to the underlying writer if it
+// has not already been written, unless opts.OmitStreamID is set.
+func (sz *writer) writeStreamID() error {
+	if sz.sentStreamID {
+		return nil
+	}
+	if sz.headerErr != nil {
+		return sz.headerErr
+	}
+	if !sz.opts.OmitStreamID {
+		err := sz.writeFull(streamID)
+		if err != nil {
+			return fmt.Errorf("writing stream identifier: %w", err)
+		}
+		sz.stats.Frames++
+		sz.stats.BytesOut += int64(len(streamID))
+		sz.trace(blockStreamIdentifier, len(streamID), 0)
+	}
+	sz.sentStreamID = true
+	if sz.header != nil {
+		err := sz.writeStreamHeader(sz.header)
+		if err != nil {
+			return err
+		}
+	}
+	if sz.hasSizeHint {
+		return sz.writeSizeHint()
+	}
+	return nil
+}
+
+// writeStreamChecksum writes a chunk containing the checksum of all data
+// written.  The stream identifier is written first if necessary.
+func (sz *writer) writeStreamChecksum() error {
+	err := sz.writeStreamID()
+	if err != nil {
+		return err
+	}
+
+	checksum := maskChecksum(sz.streamCRC)
+	chunk := sz.hdr[:8]
+	chunk[0] = blockStreamChecksum
+	chunk[1] = 4
+	chunk[2] = 0
+	chunk[3] = 0
+	chunk[4] = byte(checksum)
+	chunk[5] = byte(checksum >> 8)
+	chunk[6] = byte(checksum >> 16)
+	chunk[7] = byte(checksum >> 24)
+	err = sz.writeFull(chunk)
+	if err != nil {
+		return fmt.Errorf("writing stream checksum: %w", err)
+	}
+	sz.stats.Frames++
+	sz.stats.BytesOut += int64(len(chunk))
+	sz.trace(blockStreamChecksum, len(chunk), 0)
+	return nil
+}
+
+// flushMarker is the empty padding chunk written by Writers created with the
+// FlushMarkers option.
+var flushMarker = []byte{blockPadding, 0, 0, 0}
+
+// writeFlushMarker writes a flush marker if any data blocks have been written
+// since the previous marker.
+func (sz *writer) writeFlushMarker() error {
+	if !sz.unmarked {
+		return nil
+	}
+	err := sz.writeFull(flushMarker)
+	if err != nil {
+		return fmt.Errorf("writing flush marker: %w", err)
+	}
+	sz.unmarked = false
+	sz.stats.Frames++
+	sz.stats.BytesOut += int64(len(flushMarker))
+	sz.trace(blockPadding, len(flushMarker), 0)
+	return nil
+}
+
+// maxChunkLength is the largest chunk length representable in a chunk header.
+const maxChunkLength = 1<<24 - 1
+
+// padding is the source of padding chunk data.
+var padding [4096]byte
+
+// padTo writes padding so that the number of bytes written is a multiple of
+// align.  Nothing is written if no bytes have been written.
+func (sz *writer) padTo(align int) error {
+	total := sz.stats.BytesOut
+	if total == 0 {
+		return nil
+	}
+	pad := (int64(align) - total%int64(align)) % int64(align)
+	for pad > 0 && pad < minPaddingSize {
+		pad += int64(align)
+	}
+	return sz.writePadding(pad)
+}
+
+// minPaddingSize is the size of the smallest padding chunk written, including
+// its header.  Padding chunks always carry data because an empty padding chunk
+// is a flush marker.
+const minPaddingSize = 5
+
+// writePadding writes padding chunks totaling n bytes, including headers.  n
+// must be zero or at least minPaddingSize.
+func (sz *writer) writePadding(n int64) error {
+	for n > 0 {
+		length := n - 4
+		if length > maxChunkLength {
+			length = maxChunkLength
+			if rem := n - length - 4; rem > 0 && rem < minPaddingSize {
+				// leave room for another non-empty chunk
+				length -= minPaddingSize - rem
+			}
+		}
+
+		hdr := sz.hdr[:4]
+		hdr[0] = blockPadding
+		hdr[1] = byte(length)
+		hdr[2] = byte(length >> 8)
+		hdr[3] = byte(length >> 16)
+		err := sz.writeFull(hdr)
+		if err != nil {
+			return fmt.Errorf("writing padding header: %w", err)
+		}
+		sz.stats.Frames++
+		sz.stats.BytesOut += int64(len(hdr))
+
+		for m := length; m > 0; {
+			p := padding[:]
+			if m < int64(len(p)) {
+				p = p[:m]
+			}
+			err := sz.writeFull(p)
+			if err != nil {
+				return fmt.Errorf("writing padding: %w", err)
+			}
+			sz.stats.BytesOut += int64(len(p))
+			m -= int64(len(p))
+		}
+		sz.trace(blockPadding, int(length)+4, 0)
+
+		n -= length + 4
+	}
+	return nil
+}
+
+// compress returns true if a block of srcLen bytes which compresses to encLen
+// bytes should be written compressed.  An empty block is always compressed
+// because an uncompressed block must contain data.  A nil opts is equivalent
+// to the zero WriterOptions.
+func (opts *WriterOptions) compress(encLen, srcLen int) bool {
+	if srcLen == 0 {
+		return true
+	}
+	if opts == nil {
+		return encLen < srcLen
+	}
+	if opts.AlwaysCompress {
+		return true
+	}
+	if opts.MinCompressionRatio > 0 {
+		return float64(encLen) <= float64(srcLen)*(1-opts.MinCompressionRatio)
+	}
+	return encLen < srcLen
+}
+
+// checksum returns the checksum stored in a data chunk with decoded data p.
+func (sz *writer) checksum(p []byte) uint32 {
+	if sz.opts.Checksum != nil {
+		return sz.opts.Checksum(p)
+	}
+	return crc(p)
+}
+
 // writeHeader panics if len(hdr) is less than 8.
-func writeHeader(hdr []byte, btype byte, enc, dec []byte) {
+func writeHeader(hdr []byte, btype byte, enc []byte, checksum uint32) {
 	hdr[0] = btype
 
 	// 3 byte little endian length of encoded content
@@ -226,8 +992,7 @@ func writeHeader(hdr []byte, btype byte, enc, dec []byte) {
 	hdr[2] = byte(length >> 8)
 	hdr[3] = byte(length >> 16)
 
-	// 4 byte little endian CRC32 checksum of decoded content
-	checksum := maskChecksum(crc32.Checksum(dec, crcTable))
+	// 4 byte little endian checksum of decoded content
 	hdr[4] = byte(checksum)
 	hdr[5] = byte(checksum >> 8)
 	hdr[6] = byte(checksum >> 16)
@@ -2,9 +2,18 @@ package snappyframed
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/golang/snappy"
 )
 
 // This test ensures that all Writer methods fail after Close has been
@@ -78,6 +87,16 @@ func TestWriter_compression(t *testing.T) {
 	t.Logf("Writer compression ratio %g (%.03g factor improvement over %g)", bufc, improved, c)
 }
 
+// This test checks that encoding an oversize block fails with
+// ErrBlockTooLarge.
+func TestWriter_writeTooLarge(t *testing.T) {
+	w := newWriter(ioutil.Discard)
+	n, err := w.write(make([]byte, maxBlockSize+1))
+	if n != 0 || !errors.Is(err, ErrBlockTooLarge) {
+		t.Fatalf("write oversize block: %d %v", n, err)
+	}
+}
+
 // This tests ensures flushing after every write is equivalent to using
 // NewWriter directly.
 func TestWriterFlush(t *testing.T) {
@@ -162,3 +181,1041 @@ func TestWriterReset(t *testing.T) {
 		t.Errorf("buffer 2: %q", b2)
 	}
 }
+
+// This test checks that Writer statistics reflect the compressibility of the
+// encoded data.
+func TestWriterStats(t *testing.T) {
+	random := make([]byte, 4*maxBlockSize)
+	_, err := rand.Read(random)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name         string
+		p            []byte
+		uncompressed bool
+	}{
+		{"random", random, true},
+		{"manpage", testDataMan, false},
+	} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		_, err := w.Write(test.p)
+		if err != nil {
+			t.Errorf("%s: write: %v", test.name, err)
+			continue
+		}
+		err = w.Close()
+		if err != nil {
+			t.Errorf("%s: close: %v", test.name, err)
+			continue
+		}
+
+		stats := w.Stats()
+		if stats.BlocksTotal == 0 {
+			t.Errorf("%s: no blocks counted", test.name)
+			continue
+		}
+		if stats.BytesIn != int64(len(test.p)) {
+			t.Errorf("%s: bytes in %d (!= %d)", test.name, stats.BytesIn, len(test.p))
+		}
+		if stats.BytesOut != int64(buf.Len()) {
+			t.Errorf("%s: bytes out %d (!= %d)", test.name, stats.BytesOut, buf.Len())
+		}
+		mostlyUncompressed := 2*stats.BlocksUncompressed > stats.BlocksTotal
+		if mostlyUncompressed != test.uncompressed {
+			t.Errorf("%s: %d of %d blocks uncompressed", test.name, stats.BlocksUncompressed, stats.BlocksTotal)
+		}
+		t.Logf("%s compression ratio %.03g", test.name, stats.Ratio())
+
+		w.Reset(ioutil.Discard)
+		if w.Stats() != (WriterStats{}) {
+			t.Errorf("%s: stats not cleared by reset: %+v", test.name, w.Stats())
+		}
+	}
+}
+
+// This test checks that Buffered and Available reflect data written to the
+// Writer but not yet flushed.
+func TestWriterBuffered(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	if w.Buffered() != 0 {
+		t.Fatalf("buffered before write: %d", w.Buffered())
+	}
+	if w.Available() != maxBlockSize {
+		t.Fatalf("available before write: %d", w.Available())
+	}
+
+	p := []byte("hello snappystream!")
+	for i := 1; i <= 10; i++ {
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if w.Buffered() != i*len(p) {
+			t.Fatalf("buffered after write %d: %d", i, w.Buffered())
+		}
+		if w.Available() != maxBlockSize-i*len(p) {
+			t.Fatalf("available after write %d: %d", i, w.Available())
+		}
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("data written before flush: %q", buf.Bytes())
+	}
+
+	err := w.Flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if w.Buffered() != 0 {
+		t.Fatalf("buffered after flush: %d", w.Buffered())
+	}
+	if w.Available() != maxBlockSize {
+		t.Fatalf("available after flush: %d", w.Available())
+	}
+	if buf.Len() == 0 {
+		t.Fatalf("no data written by flush")
+	}
+}
+
+// This test checks that FlushFrame writes the stream identifier and at most
+// one frame.
+func TestWriterFlushFrame(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	err := w.FlushFrame()
+	if err != nil {
+		t.Fatalf("flush empty: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), streamID) {
+		t.Fatalf("flush empty: %q", buf.Bytes())
+	}
+
+	err = w.FlushFrame()
+	if err != nil {
+		t.Fatalf("flush empty again: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), streamID) {
+		t.Fatalf("flush empty again: %q", buf.Bytes())
+	}
+
+	p := []byte("hello snappystream!")
+	for i := 0; i < 10; i++ {
+		_, err = w.Write(p)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	err = w.FlushFrame()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	stats := w.Stats()
+	if stats.BlocksTotal != 1 {
+		t.Fatalf("flush: wrote %d blocks", stats.BlocksTotal)
+	}
+	if stats.BytesOut != int64(buf.Len()) {
+		t.Fatalf("flush: unexpected stream length %d (!= %d)", buf.Len(), stats.BytesOut)
+	}
+
+	dec, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(dec, bytes.Repeat(p, 10)) {
+		t.Fatalf("read: unexpected content %q", dec)
+	}
+}
+
+// This test checks that PadTo aligns the encoded stream without altering its
+// decoded content.
+func TestWriterPadTo(t *testing.T) {
+	p := testDataMan[:1000]
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write(p)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	unpadded := buf.Len()
+
+	for _, align := range []int{
+		1,
+		4096,
+		unpadded + 2, // requires more than align bytes of padding
+		unpadded + 4, // an empty padding chunk would be a flush marker
+		unpadded + 5,
+		1<<24 + 2,                     // requires multiple padding chunks
+		unpadded + maxChunkLength + 4, // fits a single padding chunk exactly
+		unpadded + maxChunkLength + 6,
+	} {
+		buf.Truncate(unpadded)
+		w.w.stats.BytesOut = int64(unpadded)
+
+		err = w.PadTo(align)
+		if err != nil {
+			t.Errorf("pad %d: %v", align, err)
+			continue
+		}
+		if buf.Len()%align != 0 {
+			t.Errorf("pad %d: unaligned length %d", align, buf.Len())
+		}
+		if w.Stats().BytesOut != int64(buf.Len()) {
+			t.Errorf("pad %d: bytes out %d (!= %d)", align, w.Stats().BytesOut, buf.Len())
+		}
+		for _, chunk := range splitChunks(t, buf.Bytes()[unpadded:]) {
+			if chunk[0] != blockPadding || len(chunk) < minPaddingSize {
+				t.Errorf("pad %d: invalid padding chunk %x", align, chunk[:4])
+			}
+		}
+
+		dec, err := ioutil.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+		if err != nil {
+			t.Errorf("pad %d: read: %v", align, err)
+		}
+		if !bytes.Equal(dec, p) {
+			t.Errorf("pad %d: unexpected content", align)
+		}
+	}
+
+	err = w.PadTo(0)
+	if err == nil {
+		t.Errorf("pad 0: expected error")
+	}
+}
+
+// This test checks that errors from the underlying io.Writer identify the
+// part of the stream being written.
+func TestWriterErrorContext(t *testing.T) {
+	errFail := fmt.Errorf("injected failure")
+	for i, context := range []string{
+		"stream identifier",
+		"block header",
+		"block data",
+	} {
+		w := NewWriter(&writerFailN{n: i, err: errFail})
+		_, err := w.Write([]byte("hello"))
+		if err != nil {
+			t.Fatalf("%s: write: %v", context, err)
+		}
+		err = w.Flush()
+		if err == nil {
+			t.Errorf("%s: expected error", context)
+			continue
+		}
+		if !errors.Is(err, errFail) {
+			t.Errorf("%s: unwrapped error %v", context, err)
+		}
+		if !strings.Contains(err.Error(), context) {
+			t.Errorf("%s: missing context: %v", context, err)
+		}
+	}
+}
+
+// writerFailN is an io.Writer that returns err from the call to Write
+// following n successful calls.
+type writerFailN struct {
+	n   int
+	err error
+}
+
+func (w *writerFailN) Write(p []byte) (int, error) {
+	if w.n <= 0 {
+		return 0, w.err
+	}
+	w.n--
+	return len(p), nil
+}
+
+// This test checks the number of frames counted by Writer.Stats.
+func TestWriterStats_frames(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{StreamChecksum: true})
+	_, err := w.Write(make([]byte, 3*maxBlockSize+1))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	// stream identifier and 4 data blocks
+	if w.Stats().Frames != 5 {
+		t.Fatalf("flush: %d frames", w.Stats().Frames)
+	}
+
+	err = w.PadTo(4096)
+	if err != nil {
+		t.Fatalf("pad: %v", err)
+	}
+	if w.Stats().Frames != 6 {
+		t.Fatalf("pad: %d frames", w.Stats().Frames)
+	}
+
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if w.Stats().Frames != 7 {
+		t.Fatalf("close: %d frames", w.Stats().Frames)
+	}
+	if n := len(splitChunks(t, buf.Bytes())); n != 7 {
+		t.Fatalf("stream contains %d frames", n)
+	}
+}
+
+// This test checks that the AlwaysCompress option causes incompressible data
+// to be written in compressed chunks which still decode.
+func TestWriterAlwaysCompress(t *testing.T) {
+	data := make([]byte, 2*MaxBlockSize+100)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{AlwaysCompress: true})
+	_, err = w.Write(data)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if w.Stats().BlocksUncompressed != 0 {
+		t.Fatalf("uncompressed blocks: %d", w.Stats().BlocksUncompressed)
+	}
+	for _, chunk := range splitChunks(t, buf.Bytes()[len(streamID):]) {
+		if chunk[0] != blockCompressed {
+			t.Fatalf("chunk type %#x", chunk[0])
+		}
+	}
+
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+// This test checks that a buffer larger than a block coalesces writes while
+// blocks remain bounded by MaxBlockSize.
+func TestWriterBufferSize(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{BufferSize: 4 * MaxBlockSize})
+	if w.Available() != 4*MaxBlockSize {
+		t.Fatalf("available: %d", w.Available())
+	}
+
+	data := make([]byte, 3*MaxBlockSize+MaxBlockSize/2)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	for i := 0; i < len(data); i += 1000 {
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := w.Write(data[i:end])
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	if w.Buffered() != len(data) || buf.Len() != 0 {
+		t.Fatalf("buffered %d and wrote %d bytes", w.Buffered(), buf.Len())
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	chunks := splitChunks(t, buf.Bytes()[len(streamID):])
+	if len(chunks) != 4 {
+		t.Fatalf("chunks: %d", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if len(chunk)-blockHeaderSize > MaxBlockSize {
+			t.Fatalf("chunk too large: %d", len(chunk))
+		}
+	}
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+// This test checks that MinCompressionRatio controls whether a block which
+// compresses moderately is written compressed.
+func TestWriterMinCompressionRatio(t *testing.T) {
+	// half random, half repetitive data compresses to roughly half its size.
+	data := make([]byte, MaxBlockSize)
+	_, err := rand.Read(data[:MaxBlockSize/2])
+	if err != nil {
+		t.Fatalf("rand: %v", err)
+	}
+	enc := snappy.Encode(nil, data)
+	saved := 1 - float64(len(enc))/float64(len(data))
+
+	for _, test := range []struct {
+		ratio      float64
+		compressed bool
+	}{
+		{0, true},
+		{saved - 0.05, true},
+		{saved + 0.05, false},
+		{1, false},
+	} {
+		var buf bytes.Buffer
+		w := NewWriterOptions(&buf, &WriterOptions{MinCompressionRatio: test.ratio})
+		_, err := w.Write(data)
+		if err != nil {
+			t.Fatalf("ratio %.2f: write: %v", test.ratio, err)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("ratio %.2f: close: %v", test.ratio, err)
+		}
+		chunks := splitChunks(t, buf.Bytes()[len(streamID):])
+		if len(chunks) != 1 {
+			t.Fatalf("ratio %.2f: %d chunks", test.ratio, len(chunks))
+		}
+		if (chunks[0][0] == blockCompressed) != test.compressed {
+			t.Errorf("ratio %.2f: chunk type %#x", test.ratio, chunks[0][0])
+		}
+		p, err := ioutil.ReadAll(NewReader(&buf))
+		if err != nil || !bytes.Equal(p, data) {
+			t.Fatalf("ratio %.2f: read: %v", test.ratio, err)
+		}
+	}
+}
+
+// This test checks that a Writer returned by NewBlockWriter encodes each write
+// immediately as a standard stream.
+func TestBlockWriter(t *testing.T) {
+	data := bytes.Repeat([]byte("pre-chunked data "), 10000)
+	var buf bytes.Buffer
+	w := NewBlockWriter(&buf)
+	for i := 0; i < len(data); i += MaxBlockSize {
+		end := i + MaxBlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := w.Write(data[i:end])
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if w.Buffered() != 0 {
+			t.Fatalf("buffered: %d", w.Buffered())
+		}
+		if w.Stats().BlocksTotal != int64(i/MaxBlockSize+1) {
+			t.Fatalf("blocks after write: %d", w.Stats().BlocksTotal)
+		}
+	}
+	_, err := w.Write(data[:2*MaxBlockSize+1])
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, append(data, data[:2*MaxBlockSize+1]...)) {
+		t.Fatalf("decoded data differs")
+	}
+}
+
+func BenchmarkWriterPrechunked(b *testing.B) {
+	benchmarkWriterPrechunked(b, NewWriter(ioutil.Discard))
+}
+
+func BenchmarkBlockWriterPrechunked(b *testing.B) {
+	benchmarkWriterPrechunked(b, NewBlockWriter(ioutil.Discard))
+}
+
+// benchmarkWriterPrechunked benchmarks writing blocks of MaxBlockSize bytes to
+// w.
+func benchmarkWriterPrechunked(b *testing.B, w *Writer) {
+	p := bytes.Repeat(testDataJSON, 100)
+	p = p[:len(p)/MaxBlockSize*MaxBlockSize]
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		for j := 0; j < len(p); j += MaxBlockSize {
+			_, err := w.Write(p[j : j+MaxBlockSize])
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+		err := w.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// This test checks that a Writer completes writes to an underlying writer
+// which writes short counts without an error.
+func TestWriterShortWrites(t *testing.T) {
+	data := bytes.Repeat([]byte("short writes "), 10000)
+	var buf bytes.Buffer
+	w := NewWriterOptions(&shortWriter{w: &buf, max: 3}, &WriterOptions{
+		StreamChecksum: true,
+		Header:         &Header{Name: "short"},
+	})
+	_, err := w.Write(data)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.PadTo(512)
+	if err != nil {
+		t.Fatalf("pad: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	r := NewReader(&buf)
+	r.VerifyStreamChecksum(true)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+
+	w = NewWriter(&shortWriter{w: &buf, max: 0})
+	w.Write(data)
+	err = w.Close()
+	if !errors.Is(err, io.ErrShortWrite) {
+		t.Fatalf("writer without progress: %v", err)
+	}
+}
+
+// shortWriter is an io.Writer which writes at most max bytes per call to Write
+// and never returns an error.
+type shortWriter struct {
+	w   io.Writer
+	max int
+}
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.max {
+		p = p[:w.max]
+	}
+	return w.w.Write(p)
+}
+
+// This test checks that CloseAligned completes the stream and aligns the
+// output.
+func TestWriterCloseAligned(t *testing.T) {
+	p := testDataMan[:1000]
+	for _, opts := range []*WriterOptions{nil, {StreamChecksum: true}} {
+		var buf bytes.Buffer
+		w := NewWriterOptions(&buf, opts)
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		err = w.CloseAligned(4096)
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		if buf.Len() == 0 || buf.Len()%4096 != 0 {
+			t.Fatalf("unaligned length %d", buf.Len())
+		}
+		_, err = w.Write(p)
+		if err == nil {
+			t.Fatalf("write after close: expected error")
+		}
+
+		r := NewReader(&buf)
+		r.VerifyStreamChecksum(opts != nil)
+		dec, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(dec, p) {
+			t.Fatalf("decoded data differs")
+		}
+	}
+
+	w := NewWriter(ioutil.Discard)
+	err := w.CloseAligned(0)
+	if err == nil {
+		t.Fatalf("expected error for invalid alignment")
+	}
+}
+
+// This test checks that data appended with NewAppendWriter continues the
+// existing stream instead of beginning a new one.
+func TestAppendWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write([]byte("first half, "))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	n := buf.Len()
+
+	w = NewAppendWriter(&buf, true)
+	_, err = w.Write([]byte("second half"))
+	if err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close append: %v", err)
+	}
+	if bytes.Equal(buf.Bytes()[n:n+len(streamID)], streamID) {
+		t.Fatalf("stream identifier appended")
+	}
+
+	r := NewReader(&buf)
+	r.Multistream(false)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "first half, second half" {
+		t.Fatalf("decoded: %q", p)
+	}
+
+	// without the flag a new stream identifier is written.
+	buf.Reset()
+	w = NewAppendWriter(&buf, false)
+	w.Write([]byte("data"))
+	w.Close()
+	if !bytes.HasPrefix(buf.Bytes(), streamID) {
+		t.Fatalf("missing stream identifier")
+	}
+}
+
+// This test checks that data read by ReadFrom before the source fails is
+// encoded and that the Writer remains usable.
+func TestWriterReadFrom_sourceError(t *testing.T) {
+	errSource := errors.New("source failed")
+	data := bytes.Repeat([]byte("partial data "), MaxBlockSize/5)
+	for _, prefix := range []string{"", "buffered "} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf)
+		_, err := w.Write([]byte(prefix))
+		if err != nil {
+			t.Fatalf("%q: write: %v", prefix, err)
+		}
+		r := io.MultiReader(bytes.NewReader(data), &errReader{errSource})
+		n, err := w.ReadFrom(r)
+		if err != errSource {
+			t.Fatalf("%q: read from: %v", prefix, err)
+		}
+		if n != int64(len(data)) {
+			t.Fatalf("%q: read %d bytes (!= %d)", prefix, n, len(data))
+		}
+		if w.Buffered() != 0 {
+			t.Fatalf("%q: %d bytes buffered", prefix, w.Buffered())
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("%q: close: %v", prefix, err)
+		}
+
+		p, err := ioutil.ReadAll(NewReader(&buf))
+		if err != nil {
+			t.Fatalf("%q: read: %v", prefix, err)
+		}
+		if string(p) != prefix+string(data) {
+			t.Fatalf("%q: decoded %d bytes", prefix, len(p))
+		}
+	}
+}
+
+// This test checks that encoding full blocks does not allocate once a Writer
+// has been created, not even to grow the encoding buffer on the first Write.
+func TestWriterAllocs(t *testing.T) {
+	p := make([]byte, MaxBlockSize)
+	_, err := rand.Read(p[:MaxBlockSize/2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(ioutil.Discard)
+	dst := &w.w.dst[:1][0]
+	_, err = w.Write(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &w.w.dst[:1][0] != dst {
+		t.Fatalf("encoding buffer reallocated")
+	}
+	allocs := testing.AllocsPerRun(100, func() {
+		_, err := w.Write(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("%v allocations per block", allocs)
+	}
+}
+
+func BenchmarkWriterFullBlocks(b *testing.B) {
+	p := bytes.Repeat(testDataJSON, MaxBlockSize/len(testDataJSON)+1)[:MaxBlockSize]
+	w := NewWriter(ioutil.Discard)
+	b.SetBytes(int64(len(p)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := w.Write(p)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// This test checks that a Writer with a flush policy encodes buffered data
+// once the threshold is reached.
+func TestWriterSetFlushPolicy(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetFlushPolicy(100)
+	_, err := w.Write(make([]byte, 99))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if buf.Len() != 0 || w.Buffered() != 99 {
+		t.Fatalf("flushed below threshold: %d bytes written, %d buffered", buf.Len(), w.Buffered())
+	}
+	_, err = w.Write(make([]byte, 1))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if w.Buffered() != 0 || w.Stats().BlocksTotal != 1 {
+		t.Fatalf("not flushed at threshold: %d buffered, %d blocks", w.Buffered(), w.Stats().BlocksTotal)
+	}
+	_, err = w.Write(make([]byte, 250))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if w.Buffered() != 0 || w.Stats().BlocksTotal != 2 {
+		t.Fatalf("not flushed above threshold: %d buffered, %d blocks", w.Buffered(), w.Stats().BlocksTotal)
+	}
+
+	// the policy is retained by Reset.
+	buf.Reset()
+	w.Reset(&buf)
+	_, err = w.Write(make([]byte, 100))
+	if err != nil {
+		t.Fatalf("write after reset: %v", err)
+	}
+	if w.Buffered() != 0 {
+		t.Fatalf("not flushed after reset")
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil || len(p) != 100 {
+		t.Fatalf("decoded %d bytes: %v", len(p), err)
+	}
+}
+
+// This test checks that an encoding error is reported with context and that
+// Reset recovers the Writer.
+func TestWriter_encodeError(t *testing.T) {
+	errEncode := errors.New("encoder failure")
+	encode := snappyEncode
+	defer func() { snappyEncode = encode }()
+	snappyEncode = func(dst, src []byte) ([]byte, error) {
+		return nil, errEncode
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write([]byte("data"))
+	if err != nil {
+		t.Fatalf("buffered write: %v", err)
+	}
+	err = w.Flush()
+	if !errors.Is(err, errEncode) || !strings.HasPrefix(err.Error(), "snappy encode: ") {
+		t.Fatalf("flush: %v", err)
+	}
+	_, err = w.Write([]byte("more data"))
+	if !errors.Is(err, errEncode) {
+		t.Fatalf("write after failure: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes written", buf.Len())
+	}
+
+	snappyEncode = encode
+	w.Reset(&buf)
+	_, err = w.Write([]byte("recovered"))
+	if err != nil {
+		t.Fatalf("write after reset: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close after reset: %v", err)
+	}
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "recovered" {
+		t.Fatalf("read: %q", p)
+	}
+}
+
+// This test checks that a stream written without a stream identifier is
+// decoded by a Reader which allows a missing identifier.
+func TestWriterOmitStreamID(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{OmitStreamID: true})
+	err := w.FlushFrame()
+	if err != nil {
+		t.Fatalf("flush frame: %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("%d bytes written without data", buf.Len())
+	}
+	_, err = w.Write([]byte("compact"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), streamID) {
+		t.Fatalf("stream identifier written")
+	}
+	if int64(buf.Len()) != w.Stats().BytesOut {
+		t.Fatalf("stats: %d bytes out (!= %d)", w.Stats().BytesOut, buf.Len())
+	}
+
+	_, err = ioutil.ReadAll(NewReader(bytes.NewReader(buf.Bytes())))
+	if err == nil {
+		t.Fatalf("conformant reader accepted the stream")
+	}
+	r := NewReader(&buf)
+	r.AllowMissingStreamID(true)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "compact" {
+		t.Fatalf("read: %q", p)
+	}
+}
+
+// This test checks that blocks written after SetBlockSize have the new size
+// and that the resulting stream decodes.
+func TestWriterSetBlockSize(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 5)[:3000+100000+10]
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	var sizes []int
+	w.SetTracer(func(evt FrameEvent) {
+		if evt.Decoded > 0 {
+			sizes = append(sizes, evt.Decoded)
+		}
+	})
+
+	err := w.SetBlockSize(1000)
+	if err != nil {
+		t.Fatalf("set block size: %v", err)
+	}
+	w.Write(data[:3000])
+	if w.Available() != 1000 || w.Buffered() != 0 {
+		t.Fatalf("buffer not resized: %d available, %d buffered", w.Available(), w.Buffered())
+	}
+	_, err = w.Write(data[3000:3010])
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.SetBlockSize(MaxBlockSize)
+	if err != nil {
+		t.Fatalf("set block size: %v", err)
+	}
+	_, err = w.Write(data[3010:])
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	expect := []int{1000, 1000, 1000, 10, MaxBlockSize, 100000 - MaxBlockSize}
+	if !reflect.DeepEqual(sizes, expect) {
+		t.Fatalf("block sizes %v (!= %v)", sizes, expect)
+	}
+
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decoded data differs")
+	}
+
+	for _, n := range []int{0, -1, MaxBlockSize + 1} {
+		if w.SetBlockSize(n) == nil {
+			t.Errorf("block size %d accepted", n)
+		}
+	}
+}
+
+// This test checks that a hash set with SetPlaintextHash receives all data
+// written, through both Write and ReadFrom.
+func TestWriterSetPlaintextHash(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 3)
+	half := len(data) / 2
+
+	h := sha256.New()
+	w := NewWriter(ioutil.Discard)
+	w.SetPlaintextHash(h)
+	_, err := w.Write(data[:half])
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_, err = w.ReadFrom(bytes.NewReader(data[half:]))
+	if err != nil {
+		t.Fatalf("read from: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	expect := sha256.Sum256(data)
+	if !bytes.Equal(h.Sum(nil), expect[:]) {
+		t.Fatalf("hash %x (!= %x)", h.Sum(nil), expect)
+	}
+
+	// blocks encoded concurrently by EncodeReaderAt.
+	data = bytes.Repeat(testDataJSON, 2)
+	h.Reset()
+	w.Reset(ioutil.Discard)
+	_, err = w.EncodeReaderAt(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("encode reader at: %v", err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	expect = sha256.Sum256(data)
+	if !bytes.Equal(h.Sum(nil), expect[:]) {
+		t.Fatalf("EncodeReaderAt: hash %x (!= %x)", h.Sum(nil), expect)
+	}
+}
+
+// This test checks that a Writer produces the same stream through ReadFrom
+// after DisableReadFrom, but buffers data as Write does.
+func TestWriterDisableReadFrom(t *testing.T) {
+	data := bytes.Repeat(testDataJSON, 10)
+
+	var fast, slow bytes.Buffer
+	for _, buf := range []*bytes.Buffer{&fast, &slow} {
+		w := NewWriter(buf)
+		if buf == &slow {
+			w.DisableReadFrom()
+		}
+		// hide the source's WriterTo so the generic path copies in pieces.
+		n, err := w.ReadFrom(struct{ io.Reader }{bytes.NewReader(data)})
+		if err != nil {
+			t.Fatalf("read from: %v", err)
+		}
+		if n != int64(len(data)) {
+			t.Fatalf("read %d bytes (!= %d)", n, len(data))
+		}
+		buffered := len(data) % MaxBlockSize
+		if buf == &fast {
+			buffered = 0
+		}
+		if w.Buffered() != buffered {
+			t.Fatalf("%d bytes buffered (!= %d)", w.Buffered(), buffered)
+		}
+		err = w.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+	if !bytes.Equal(fast.Bytes(), slow.Bytes()) {
+		t.Fatalf("streams differ")
+	}
+}
+
+// This test checks that the EagerStreamID option writes the stream identifier
+// before any data is written.
+func TestWriterEagerStreamID(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriterOptions(&buf, &WriterOptions{EagerStreamID: true})
+	if !bytes.Equal(buf.Bytes(), streamID) {
+		t.Fatalf("stream identifier not written: %x", buf.Bytes())
+	}
+	w.Write([]byte("data"))
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if bytes.Count(buf.Bytes(), streamID) != 1 {
+		t.Fatalf("stream identifier written more than once")
+	}
+	p, err := ioutil.ReadAll(NewReader(&buf))
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if string(p) != "data" {
+		t.Fatalf("read: %q", p)
+	}
+
+	buf.Reset()
+	w.Reset(&buf)
+	if !bytes.Equal(buf.Bytes(), streamID) {
+		t.Fatalf("stream identifier not written after Reset: %x", buf.Bytes())
+	}
+
+	// the default remains lazy.
+	buf.Reset()
+	NewWriterOptions(&buf, &WriterOptions{})
+	if buf.Len() != 0 {
+		t.Fatalf("stream identifier written eagerly by default")
+	}
+}
+
+// This test checks that writeHeader encodes the length of the largest
+// possible data block.
+func TestWriteHeader_maxLength(t *testing.T) {
+	hdr := make([]byte, blockHeaderSize)
+	writeHeader(hdr, blockCompressed, make([]byte, maxEncodedBlockSize), 0)
+	if n := decodeLength(hdr[1:]); n != maxEncodedBlockSize+4 {
+		t.Fatalf("length %d (!= %d)", n, maxEncodedBlockSize+4)
+	}
+}